@@ -0,0 +1,120 @@
+package lfu
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+const benchKeys = 10000
+
+// zipfKeys returns n keys drawn from benchKeys distinct keys with a
+// zipfian distribution, so that a few keys are very hot.
+func zipfKeys(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.1, 1, benchKeys-1)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%v", z.Uint64())
+	}
+	return keys
+}
+
+func filled() *Cache {
+	c := New()
+	for i := 0; i < benchKeys; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	return c
+}
+
+func Benchmark_Get(b *testing.B) {
+	c := filled()
+	keys := zipfKeys(4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+// Benchmark_Set overwrites existing keys.
+func Benchmark_Set(b *testing.B) {
+	c := filled()
+	keys := zipfKeys(4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Set(keys[i%len(keys)], i)
+	}
+}
+
+// Benchmark_SetEvict inserts new keys into a full cache, so every
+// Set pays for eviction.
+func Benchmark_SetEvict(b *testing.B) {
+	c := New()
+	c.UpperBound = benchKeys
+	c.LowerBound = benchKeys * 9 / 10
+	keys := make([]string, 4*benchKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%v", i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Set(keys[i%len(keys)], i)
+	}
+}
+
+// Benchmark_SetEvictFIFO is Benchmark_SetEvict for a fixed-size
+// cache with TieBreakFIFO, where every Set evicts one entry from a
+// large bucket.
+func Benchmark_SetEvictFIFO(b *testing.B) {
+	c := New()
+	c.UpperBound = benchKeys
+	c.LowerBound = benchKeys
+	c.TieBreakFIFO = true
+	keys := make([]string, 4*benchKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%v", i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Set(keys[i%len(keys)], i)
+	}
+}
+
+// Benchmark_Increment stresses promotion between buckets by reading
+// keys spread over many frequencies.
+func Benchmark_Increment(b *testing.B) {
+	c := filled()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries := make([]*cacheEntry, 0, benchKeys)
+	for _, e := range c.values {
+		entries = append(entries, e)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.increment(entries[i%len(entries)])
+	}
+}
+
+// Benchmark_Mixed runs nine Gets per Set from parallel goroutines, to
+// include lock contention.
+func Benchmark_Mixed(b *testing.B) {
+	c := filled()
+	c.UpperBound = benchKeys
+	c.LowerBound = benchKeys * 9 / 10
+	keys := zipfKeys(4096)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(len(keys))
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%10 == 0 {
+				c.Set(key, i)
+			} else {
+				c.Get(key)
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,18 @@
+package lfu
+
+// call runs a user callback, recovering a panic into OnCallbackPanic
+// if it is set.
+func (c *Cache) call(fn func()) {
+	protect(c.OnCallbackPanic, fn)
+}
+
+func protect(onPanic func(interface{}), fn func()) {
+	if onPanic != nil {
+		defer func() {
+			if r := recover(); r != nil {
+				onPanic(r)
+			}
+		}()
+	}
+	fn()
+}
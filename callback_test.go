@@ -0,0 +1,41 @@
+package lfu
+
+import (
+	"testing"
+)
+
+func TestOnCallbackPanic(t *testing.T) {
+	var recovered []interface{}
+
+	c := New()
+	c.SelfCheck = true
+	c.OnCorruption = func(err error) { t.Error(err) }
+	c.OnCallbackPanic = func(r interface{}) {
+		recovered = append(recovered, r)
+	}
+	c.Sizer = func(key string, value interface{}) int64 {
+		panic("sizer")
+	}
+	c.OnDirtyEvict = func(ev Eviction) {
+		panic("evict")
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if n := c.Evict(1); n != 1 {
+		t.Errorf("Eviction did not complete: %v", n)
+	}
+	if len(recovered) != 3 || recovered[2] != "evict" {
+		t.Errorf("Panics were not recovered: %v", recovered)
+	}
+	if c.Len() != 1 || c.CurrentCost() != 0 {
+		t.Errorf("Cache is inconsistent: %v, %v", c.Len(), c.CurrentCost())
+	}
+
+	c.OnCallbackPanic = nil
+	defer func() {
+		if r := recover(); r != "sizer" {
+			t.Errorf("Panic did not propagate: %v", r)
+		}
+	}()
+	c.Set("c", 3)
+}
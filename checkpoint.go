@@ -0,0 +1,50 @@
+package lfu
+
+import "time"
+
+// CacheCheckpoint is the frequency distribution of a cache at one
+// point in time.
+type CacheCheckpoint struct {
+	Time time.Time
+	Len  int
+	// Buckets maps each frequency present to its number of entries.
+	Buckets map[int]int
+}
+
+// CheckpointDiff is the change between two checkpoints.
+type CheckpointDiff struct {
+	Elapsed time.Duration
+	Len     int
+	// Buckets maps each frequency whose population changed to the
+	// change.
+	Buckets map[int]int
+}
+
+// Checkpoint records the current frequency distribution, for later
+// comparison with DiffCheckpoints.
+func (c *Cache) Checkpoint() CacheCheckpoint {
+	c.lock.Lock()
+	defer c.unlock()
+	cp := CacheCheckpoint{Time: c.now(), Len: c.len, Buckets: make(map[int]int, c.freqs.Len())}
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		li := place.Value.(*listEntry)
+		cp.Buckets[li.freq] = li.entries.Len()
+	}
+	return cp
+}
+
+// DiffCheckpoints returns how the distribution changed from a to b.
+func DiffCheckpoints(a, b CacheCheckpoint) CheckpointDiff {
+	d := CheckpointDiff{Elapsed: b.Time.Sub(a.Time), Len: b.Len - a.Len, Buckets: make(map[int]int)}
+	for freq, n := range b.Buckets {
+		if delta := n - a.Buckets[freq]; delta != 0 {
+			d.Buckets[freq] = delta
+		}
+	}
+	for freq, n := range a.Buckets {
+		if _, ok := b.Buckets[freq]; !ok {
+			d.Buckets[freq] = -n
+		}
+	}
+	return d
+}
@@ -0,0 +1,24 @@
+package lfu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffCheckpoints(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("b")
+	a := c.Checkpoint()
+	c.Set("c", 3)
+	c.Get("b")
+	b := c.Checkpoint()
+	d := DiffCheckpoints(a, b)
+	if d.Len != 1 {
+		t.Errorf("Length change is wrong: %v != 1", d.Len)
+	}
+	if want := map[int]int{1: 1, 2: -1, 3: 1}; !reflect.DeepEqual(d.Buckets, want) {
+		t.Errorf("Bucket changes are wrong: %v != %v", d.Buckets, want)
+	}
+}
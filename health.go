@@ -0,0 +1,58 @@
+package lfu
+
+import (
+	"fmt"
+)
+
+const (
+	defaultMaxDirtyRatio    = 0.5
+	defaultMaxWriteBackFull = 3
+	healthMinEvictions      = 10
+)
+
+// Healthy reports whether the cache is keeping up, and if not, why.  It
+// fails when evictions were dropped because EvictSendTimeout expired
+// since the previous call; when the last HealthMaxWriteBackFull
+// (default 3) calls to WriteBack found WriteBackChannel full; or, if
+// entries are persisted through WriteBackChannel or WriteThrough, when
+// more than HealthMaxDirtyRatio (default 0.5) of the evictions in the
+// last completed window of at least 10 were of dirty entries.  It only
+// reads counters.
+func (c *Cache) Healthy() (bool, string) {
+	c.lock.Lock()
+	defer c.unlock()
+	maxDirty := c.HealthMaxDirtyRatio
+	if maxDirty <= 0 {
+		maxDirty = defaultMaxDirtyRatio
+	}
+	maxFull := c.HealthMaxWriteBackFull
+	if maxFull <= 0 {
+		maxFull = defaultMaxWriteBackFull
+	}
+	if n := c.stats.Evictions - c.health.evictions; n >= healthMinEvictions {
+		c.health.dirtyRatio = float64(c.stats.DirtyEvictions-c.health.dirty) / float64(n)
+		c.health.evictions = c.stats.Evictions
+		c.health.dirty = c.stats.DirtyEvictions
+	}
+	dropped := c.stats.DroppedEvictions - c.health.dropped
+	c.health.dropped = c.stats.DroppedEvictions
+	persists := c.WriteBackChannel != nil || c.WriteThrough.writes()
+	if persists && c.health.dirtyRatio > maxDirty {
+		return false, fmt.Sprintf("lfu: %.0f%% of recent evictions were dirty", c.health.dirtyRatio*100)
+	}
+	if dropped > 0 {
+		return false, fmt.Sprintf("lfu: %d evictions dropped", dropped)
+	}
+	if c.writeBackFull >= maxFull {
+		return false, fmt.Sprintf("lfu: WriteBackChannel full for %d write-backs", c.writeBackFull)
+	}
+	return true, ""
+}
+
+// healthWindow holds the counters Healthy compares against.
+type healthWindow struct {
+	evictions  int64
+	dirty      int64
+	dropped    int64
+	dirtyRatio float64
+}
@@ -0,0 +1,65 @@
+package lfu
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHealthy(t *testing.T) {
+	ch := make(chan Eviction, 1)
+
+	c := New()
+	c.WriteBackChannel = ch
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if ok, reason := c.Healthy(); !ok {
+		t.Errorf("New cache is unhealthy: %v", reason)
+	}
+	for i := 0; i < 3; i++ {
+		c.WriteBack(2)
+	}
+	if ok, _ := c.Healthy(); ok {
+		t.Errorf("Full WriteBackChannel was not reported")
+	}
+	<-ch
+	c.WriteBack(2)
+	if ok, reason := c.Healthy(); !ok {
+		t.Errorf("Drained WriteBackChannel still reported: %v", reason)
+	}
+
+	c = New()
+	c.UpperBound = 10
+	c.LowerBound = 5
+	for i := 0; i < 30; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if ok, reason := c.Healthy(); !ok {
+		t.Errorf("Cache without write-back is unhealthy: %v", reason)
+	}
+	c.WriteBackChannel = make(chan Eviction)
+	for i := 0; i < 30; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if ok, _ := c.Healthy(); ok {
+		t.Errorf("Dirty evictions were not reported")
+	}
+	c.HealthMaxDirtyRatio = 1
+	if ok, reason := c.Healthy(); !ok {
+		t.Errorf("HealthMaxDirtyRatio was not applied: %v", reason)
+	}
+}
+
+func TestHealthyDropped(t *testing.T) {
+	c := New()
+	c.EvictionChannel = make(chan Eviction)
+	c.EvictSendTimeout = time.Nanosecond
+	c.Set("a", 1)
+	c.Evict(1)
+	if ok, _ := c.Healthy(); ok {
+		t.Errorf("Dropped eviction was not reported")
+	}
+	if ok, reason := c.Healthy(); !ok {
+		t.Errorf("Old drop is still reported: %v", reason)
+	}
+}
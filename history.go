@@ -0,0 +1,78 @@
+package lfu
+
+import "time"
+
+// EvictionReason says why an entry left the cache.
+type EvictionReason int
+
+const (
+	// ReasonCapacity is an eviction forced by UpperBound or HardCap.
+	ReasonCapacity EvictionReason = iota
+	// ReasonManual is an eviction requested through Evict and friends.
+	ReasonManual
+	// ReasonExpired is the removal of an entry past its deadline.
+	ReasonExpired
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case ReasonCapacity:
+		return "capacity"
+	case ReasonManual:
+		return "manual"
+	case ReasonExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+// EvictionRecord describes one past eviction.
+type EvictionRecord struct {
+	Key    string
+	Freq   int
+	Reason EvictionReason
+	Time   time.Time
+}
+
+// EvictionHistory keeps a record of the last n evictions, readable
+// through RecentEvictions.  Any existing history is discarded.  n == 0
+// disables recording, which is the default.
+func (c *Cache) EvictionHistory(n int) {
+	c.lock.Lock()
+	defer c.unlock()
+	c.history = nil
+	c.historyNext = 0
+	c.historyFull = false
+	if n > 0 {
+		c.history = make([]EvictionRecord, n)
+	}
+}
+
+// RecentEvictions returns the recorded evictions, oldest first.
+func (c *Cache) RecentEvictions() []EvictionRecord {
+	c.lock.Lock()
+	defer c.unlock()
+	if !c.historyFull {
+		return append([]EvictionRecord(nil), c.history[:c.historyNext]...)
+	}
+	records := make([]EvictionRecord, 0, len(c.history))
+	records = append(records, c.history[c.historyNext:]...)
+	return append(records, c.history[:c.historyNext]...)
+}
+
+func (c *Cache) record(entry *cacheEntry, reason EvictionReason) {
+	if c.history == nil {
+		return
+	}
+	c.history[c.historyNext] = EvictionRecord{
+		Key:    entry.key,
+		Freq:   entry.freqNode.Value.(*listEntry).freq,
+		Reason: reason,
+		Time:   c.now(),
+	}
+	c.historyNext++
+	if c.historyNext == len(c.history) {
+		c.historyNext = 0
+		c.historyFull = true
+	}
+}
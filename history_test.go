@@ -0,0 +1,41 @@
+package lfu
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEvictionHistory(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	c := New()
+	c.now = func() time.Time { return now }
+	c.Set("x", 0)
+	c.Evict(1)
+	if r := c.RecentEvictions(); len(r) != 0 {
+		t.Errorf("Evictions were recorded while disabled: %v", r)
+	}
+
+	c.EvictionHistory(2)
+	c.UpperBound = 3
+	c.LowerBound = 3
+	for i := 0; i < 4; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	c.Get("1")
+	c.Evict(1)
+	c.SetWithDeadline("d", 0, now)
+	c.Get("d")
+
+	records := c.RecentEvictions()
+	if len(records) != 2 {
+		t.Fatalf("Wrong number of records: %v != 2", len(records))
+	}
+	if r := records[0]; r.Key != "2" || r.Reason != ReasonManual || r.Freq != 1 {
+		t.Errorf("Wrong record: %+v", r)
+	}
+	if r := records[1]; r.Key != "d" || r.Reason != ReasonExpired || !r.Time.Equal(now) {
+		t.Errorf("Wrong record: %+v", r)
+	}
+}
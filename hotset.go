@@ -0,0 +1,56 @@
+package lfu
+
+// defaultHotSetSize is the HotSetSize used if it is not set.
+const defaultHotSetSize = 10
+
+// TopK returns up to k of the most frequently used entries, hottest
+// first, and among equally hot entries the most recently used first.
+// It does not count as an access.
+func (c *Cache) TopK(k int) []Eviction {
+	c.lock.Lock()
+	defer c.unlock()
+	top := make([]Eviction, 0, k)
+	for _, e := range c.topK(k) {
+		top = append(top, Eviction{Key: e.key, Value: c.exposed(e)})
+	}
+	return top
+}
+
+func (c *Cache) topK(k int) []*cacheEntry {
+	var top []*cacheEntry
+	for place := c.freqs.Back(); place != nil && len(top) < k; place = place.Prev() {
+		for e := place.Value.(*listEntry).entries.tail; e != nil && len(top) < k; e = e.prev {
+			if !c.expired(e) {
+				top = append(top, e)
+			}
+		}
+	}
+	return top
+}
+
+// HotSetChanges compares the current top HotSetSize keys, 10 if it is
+// unset, with those at the previous call, and returns the keys that
+// entered and left the set since.  The first call reports the whole
+// set as entered.
+func (c *Cache) HotSetChanges() (entered, exited []string) {
+	c.lock.Lock()
+	defer c.unlock()
+	k := c.HotSetSize
+	if k <= 0 {
+		k = defaultHotSetSize
+	}
+	current := make(map[string]bool, k)
+	for _, e := range c.topK(k) {
+		current[e.key] = true
+		if !c.hotSet[e.key] {
+			entered = append(entered, e.key)
+		}
+	}
+	for key := range c.hotSet {
+		if !current[key] {
+			exited = append(exited, key)
+		}
+	}
+	c.hotSet = current
+	return entered, exited
+}
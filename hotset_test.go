@@ -0,0 +1,36 @@
+package lfu
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestHotSetChanges(t *testing.T) {
+	c := New()
+	c.HotSetSize = 2
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("a")
+	c.Get("a")
+	c.Get("b")
+	if top := c.TopK(2); len(top) != 2 || top[0].Key != "a" || top[1].Key != "b" {
+		t.Errorf("Wrong top entries: %v", top)
+	}
+	entered, exited := c.HotSetChanges()
+	sort.Strings(entered)
+	if !reflect.DeepEqual(entered, []string{"a", "b"}) || len(exited) != 0 {
+		t.Errorf("Wrong initial changes: %v, %v", entered, exited)
+	}
+	c.Get("c")
+	c.Get("c")
+	c.Get("c")
+	entered, exited = c.HotSetChanges()
+	if !reflect.DeepEqual(entered, []string{"c"}) || !reflect.DeepEqual(exited, []string{"b"}) {
+		t.Errorf("Wrong changes: %v, %v", entered, exited)
+	}
+	if entered, exited = c.HotSetChanges(); len(entered) != 0 || len(exited) != 0 {
+		t.Errorf("Changes reported twice: %v, %v", entered, exited)
+	}
+}
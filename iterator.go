@@ -0,0 +1,94 @@
+package lfu
+
+// Iterator walks a cache, coldest first, without holding the lock for
+// the whole walk.  Keys and frequencies are copied up front; values are
+// fetched in batches, so entries deleted in the meantime are skipped
+// and values may be newer than the snapshot.
+type Iterator struct {
+	c         *Cache
+	batchSize int
+	keys      []string
+	freqs     []int
+	pos       int
+	batch     []Eviction
+	batchFreq []int
+	freq      int
+}
+
+// NewIterator returns an Iterator that fetches batchSize values per
+// lock acquisition.
+func (c *Cache) NewIterator(batchSize int) *Iterator {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	it := &Iterator{c: c, batchSize: batchSize}
+	c.lock.Lock()
+	defer c.unlock()
+	it.keys = make([]string, 0, c.len)
+	it.freqs = make([]int, 0, c.len)
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		li := place.Value.(*listEntry)
+		for entry := li.entries.Front(); entry != nil; entry = entry.next {
+			it.keys = append(it.keys, entry.key)
+			it.freqs = append(it.freqs, li.freq)
+		}
+	}
+	return it
+}
+
+// Next returns the next entry still present in the cache, or false
+// when the walk is done.  Frequencies are not affected.
+func (it *Iterator) Next() (Eviction, bool) {
+	for len(it.batch) == 0 {
+		if it.pos >= len(it.keys) {
+			return Eviction{}, false
+		}
+		it.fill()
+	}
+	ev := it.batch[0]
+	it.freq = it.batchFreq[0]
+	it.batch = it.batch[1:]
+	it.batchFreq = it.batchFreq[1:]
+	return ev, true
+}
+
+// Freq returns the frequency, as of the snapshot, of the entry last
+// returned by Next.
+func (it *Iterator) Freq() int {
+	return it.freq
+}
+
+func (it *Iterator) fill() {
+	end := it.pos + it.batchSize
+	if end > len(it.keys) {
+		end = len(it.keys)
+	}
+	it.c.lock.Lock()
+	defer it.c.lock.Unlock()
+	for ; it.pos < end; it.pos++ {
+		e, ok := it.c.values[it.keys[it.pos]]
+		if !ok || it.c.expired(e) {
+			continue
+		}
+		it.batch = append(it.batch, Eviction{Key: e.key, Value: it.c.exposed(e)})
+		it.batchFreq = append(it.batchFreq, it.freqs[it.pos])
+	}
+}
+
+// exportBatch is the number of values Export fetches per lock
+// acquisition.
+const exportBatch = 64
+
+// Export sends every entry on out, coldest first, and returns the
+// number sent.  The lock is never held while sending, so a slow
+// consumer does not block the cache; entries deleted before they are
+// reached are skipped.  out is not closed.
+func (c *Cache) Export(out chan<- Eviction) int {
+	it := c.NewIterator(exportBatch)
+	var sent int
+	for ev, ok := it.Next(); ok; ev, ok = it.Next() {
+		out <- ev
+		sent++
+	}
+	return sent
+}
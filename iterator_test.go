@@ -0,0 +1,52 @@
+package lfu
+
+import "testing"
+
+func TestIterator(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("a")
+
+	it := c.NewIterator(1)
+	c.Delete("c")
+	c.Set("b", 4)
+
+	var keys []string
+	for ev, ok := it.Next(); ok; ev, ok = it.Next() {
+		keys = append(keys, ev.Key)
+		if ev.Key == "b" && ev.Value != 4 {
+			t.Errorf("Stale value was returned: %v", ev.Value)
+		}
+		if ev.Key == "a" && it.Freq() != 2 {
+			t.Errorf("Snapshot frequency is wrong: %v != 2", it.Freq())
+		}
+	}
+	if len(keys) != 2 || keys[0] != "b" || keys[1] != "a" {
+		t.Errorf("Wrong entries visited: %v", keys)
+	}
+}
+
+func TestExport(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+
+	out := make(chan Eviction)
+	done := make(chan int)
+	go func() {
+		done <- c.Export(out)
+	}()
+	first := <-out
+	// the cache stays usable while the consumer is slow
+	c.Set("c", 3)
+	second := <-out
+	if n := <-done; n != 2 {
+		t.Errorf("Number of exported entries is wrong: %v != 2", n)
+	}
+	if first.Key != "b" || second.Key != "a" {
+		t.Errorf("Wrong export order: %v, %v", first.Key, second.Key)
+	}
+}
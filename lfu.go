@@ -2,198 +2,2117 @@ package lfu
 
 import (
 	"container/list"
+	"context"
+	"errors"
+	"math"
+	"runtime"
+	"sort"
 	"sync"
+	"time"
+	"unsafe"
 )
 
+// ErrNotFound is returned by GetOrError for a missing key.
+var ErrNotFound = errors.New("lfu: key not found")
+
+// ErrNotInt64 is returned by Incr if the existing value is not an int64.
+var ErrNotInt64 = errors.New("lfu: value is not an int64")
+
+// ErrNotStored is returned by Incr and SwapValue if a new key was not
+// admitted, e.g. because the cache is at HardCap.
+var ErrNotStored = errors.New("lfu: value was not stored")
+
+// ErrNoWriteBackChannel is returned by WriteBackChecked if
+// WriteBackChannel is not set.
+var ErrNoWriteBackChannel = errors.New("lfu: no WriteBackChannel")
+
+// NoExpiration is returned by GetTTL for entries without a deadline.
+const NoExpiration time.Duration = math.MaxInt64
+
 type Eviction struct {
 	Key   string
 	Value interface{}
+	// NilValue is set on entries sent on EvictionChannel,
+	// WriteBackChannel and ExpirationChannel when nil was stored as
+	// the value, so it can be told apart from an Eviction that carries
+	// no value at all.
+	NilValue bool
+}
+
+type Cache struct {
+	// If len > UpperBound, cache will automatically evict
+	// down to LowerBound.  If either value is 0, this behavior
+	// is disabled.  With LowerBound == UpperBound the cache has a
+	// fixed size: a Set that would exceed it evicts exactly one
+	// entry, within the same lock, so len is never seen above
+	// UpperBound.  A LowerBound above UpperBound acts as UpperBound.
+	UpperBound int
+	LowerBound int
+	// If HardCap > 0, a new key is not inserted while len >= HardCap.
+	// Overwrites of existing keys always succeed.  If HardCapEvict
+	// is set, the coldest entries are evicted to make room instead.
+	HardCap      int
+	HardCapEvict bool
+	// If Sizer is set, the cache tracks the total cost of its entries,
+	// and if MaxBytes > 0 it evicts until the total is at most
+	// MaxBytes, scaled by SetMemoryPressureFunc, after every Set.
+	Sizer    func(key string, value interface{}) int64
+	MaxBytes int64
+	// If MaxEvictPerSet > 0, automatic eviction removes at most that
+	// many entries (at least 2) per Set, spreading a drain from
+	// UpperBound to LowerBound over the following writes instead of
+	// doing it all at once.  Since every write then evicts at least
+	// one entry more than it adds, the cache still reaches LowerBound,
+	// but may sit above UpperBound in the meantime.
+	MaxEvictPerSet int
+	// If MaxBuckets > 0 and the number of distinct frequencies exceeds
+	// it, the two adjacent buckets with the closest frequencies are
+	// merged into the colder one, never lowering the bucket an access
+	// just moved an entry to.  This bounds the list to MaxBuckets
+	// buckets at the cost of no longer distinguishing between, say,
+	// frequencies 6 and 7 until they are accessed again.
+	MaxBuckets int
+	// IncrementOnSet makes overwriting an existing key count as an
+	// access.  New sets it; clear it to make only reads affect
+	// eviction.  New keys always start at InitialFrequency.
+	IncrementOnSet bool
+	// InitialFrequency is the frequency new entries start at, 1 if
+	// unset.  A higher value gives new keys a grace period before they
+	// become eviction candidates.  Demote still resets entries to 1.
+	InitialFrequency int
+	// While the cache is warming up, automatic eviction by UpperBound
+	// is suspended so the working set can establish itself before
+	// anything gets evicted at frequency 1.  Warmup ends after
+	// WarmupOps calls to Get and Set, or WarmupDuration after the
+	// first of them, whichever comes first; a zero value disables
+	// that condition.  To bound memory, the cache still evicts down to
+	// LowerBound once it grows past WarmupMaxLen entries, or twice
+	// UpperBound if that is 0.  MaxBytes and HardCap apply as usual.
+	WarmupOps      int64
+	WarmupDuration time.Duration
+	WarmupMaxLen   int
+	// PreferCleanEviction makes eviction take persisted entries before
+	// dirty ones of the same frequency, to save write-backs.
+	PreferCleanEviction bool
+	// HotSetSize is the number of keys HotSetChanges tracks.
+	HotSetSize int
+	// If BulkChunk > 0, DeleteMany, EvictPrefix, PinMany, UnpinMany
+	// and RangeOlderThan release the lock after every BulkChunk keys
+	// or entries, so that long bulk operations do not starve other
+	// callers.  They are then no longer atomic: other operations may
+	// run between chunks, and entries they remove are skipped.
+	BulkChunk int
+	// RenameOverwrites makes Rename replace an existing entry at the
+	// new key instead of failing.
+	RenameOverwrites bool
+	// If CoalesceWindow > 0, accesses to an entry within CoalesceWindow
+	// of its last counted access are ignored, so a burst of reads
+	// counts once per window.  This also under-counts genuinely
+	// distinct accesses that arrive that quickly.  Ignored accesses
+	// do not update the entry's last access time.
+	CoalesceWindow time.Duration
+	// TieBreakFIFO makes eviction pick the earliest inserted entry
+	// among those with the lowest frequency, instead of the least
+	// recently used one.  Overwrites keep an entry's place in line.
+	TieBreakFIFO bool
+	values       map[string]*cacheEntry
+	freqs        *list.List
+	// buckets indexes the elements of freqs by frequency
+	buckets map[int]*list.Element
+	len     int
+	// dirty counts entries not persisted
+	dirty int
+	// filled is set once an entry is added and cleared when OnEmpty
+	// fires, see unlock
+	filled bool
+	// LFUDA state, see NewLFUDA
+	dynamicAging bool
+	age          int
+	// seq numbers entries in insertion order
+	seq             uint64
+	cost            int64
+	lock            sync.Locker
+	EvictionChannel chan<- Eviction
+	// ExpirationChannel, if set, receives every entry removed because
+	// its deadline passed, dirty or not.  Expired entries are never
+	// sent on EvictionChannel.
+	ExpirationChannel chan<- Eviction
+	// If EvictSendTimeout > 0, a send on a full EvictionChannel gives up
+	// after that long.  The eviction is then passed to OnEvictTimeout,
+	// or dropped and counted in Stats.DroppedEvictions if it is nil.
+	// Otherwise sends block until the channel has room.
+	EvictSendTimeout time.Duration
+	OnEvictTimeout   func(Eviction)
+	// If SyncEvictAboveDirty > 0, sends on EvictionChannel do not wait
+	// while at most that many entries are dirty: an eviction that does
+	// not fit is dropped and counted in Stats.SkippedEvictions.  Once
+	// more entries are dirty, sends wait as described above, so no
+	// more dirty data is lost but Set can stall behind a slow
+	// consumer until the backlog clears.
+	SyncEvictAboveDirty int
+	WriteBackChannel    chan<- Eviction
+	WriteThrough        WriteThrough
+	// DetectMutation is a debugging aid.  When set, byte slice and map
+	// values are checksummed on Set and verified on Get, to catch
+	// callers modifying cached values in place.  A mismatch calls
+	// OnMutation, or panics if it is nil.
+	DetectMutation bool
+	OnMutation     func(key string)
+	// ValueValidator, if set, is called with every value written by
+	// Set, Update, SetAtomic and the other single-key writes.  Values
+	// it returns an error for are not stored; SetChecked and Put
+	// return the error, Set drops it and SetAtomic stores nothing.
+	// Bulk loads such as Swap, Restore and Seed are not validated.
+	ValueValidator func(value interface{}) error
+	// Encode and Decode, if set, convert values between the form callers
+	// see and the form kept in the cache, e.g. to compress large
+	// strings.  Decode must invert Encode.  Encode runs on every write
+	// and Decode on every read, including entries handed out on
+	// channels, by iterators and in snapshots.  Sizer and
+	// DetectMutation see the stored form.
+	Encode func(interface{}) interface{}
+	Decode func(interface{}) interface{}
+	// SelfCheck enables cheap consistency checks after every mutation.
+	// Failures are reported to OnCorruption.
+	SelfCheck    bool
+	OnCorruption func(error)
+	// If HitWindow > 0, the outcome of the last HitWindow Gets is
+	// kept for RecentHitRatio.
+	HitWindow int
+	// OnReplace, if set, is called under the lock with the old value
+	// whenever Set, Update or any other write overwrites an entry.
+	OnReplace func(old Eviction)
+	// OnEmpty, if set, is called under the lock whenever an operation
+	// leaves a cache that held entries empty, whether through Delete,
+	// eviction, expiry, Clear or a bulk replacement.  A cache that is
+	// only empty partway through an operation, as when HardCapEvict
+	// evicts the last entry to make room for a new one, does not count.
+	// It must not call back into the cache.
+	OnEmpty func()
+	// OnOscillation, if set, is called under the lock with
+	// Stats.LastEvictionInterval whenever automatic eviction runs again
+	// fewer than OscillationSets Sets after its previous run.  Sets
+	// that spread a drain under MaxEvictPerSet evict on every write by
+	// design and are not reported.
+	OnOscillation   func(interval int64)
+	OscillationSets int
+	// If OnCallbackPanic is set, a panic in Sizer, EvictHandler,
+	// OnDirtyEvict, OnEvictTimeout, OnReplace, OnEmpty, OnOscillation,
+	// OnCorruption or a threshold function is recovered and passed
+	// to it, and the operation carries on as if the callback had
+	// returned; a panicking Sizer reports a cost of 0.  Otherwise
+	// panics propagate.  Callbacks whose results the cache depends on,
+	// such as WriteThrough and Encode, are not covered.
+	// OnCallbackPanic runs under the lock, except for EvictHandler
+	// panics, which it receives on the worker goroutine.
+	OnCallbackPanic func(interface{})
+	// OnDirtyEvict, if set, is called under the lock for every entry
+	// evicted before it was persisted.
+	OnDirtyEvict func(Eviction)
+	// If EvictHandler is set, entries that would be sent on
+	// EvictionChannel are also handed to a pool of EvictWorkers
+	// goroutines (at least 1) that call it outside the lock.  Eviction
+	// blocks while all workers are busy and the queue is full, so a
+	// handler that calls back into the cache can deadlock.  Close
+	// drains the queue and stops the workers.
+	EvictHandler func(Eviction)
+	EvictWorkers int
+	// Thresholds for Healthy
+	HealthMaxDirtyRatio    float64
+	HealthMaxWriteBackFull int
+	evictQueue             chan Eviction
+	evictWorkers           sync.WaitGroup
+	closed                 bool
+	stats                  CacheStats
+	// writeBackFull counts consecutive write-backs that found
+	// WriteBackChannel full
+	writeBackFull int
+	// health holds the state of Healthy's windows
+	health     healthWindow
+	thresholds []*threshold
+	// RecentHitRatio ring of the last HitWindow Gets
+	hitRing []bool
+	hitNext int
+	hitFull bool
+	// EvictionRate state
+	rate   float64
+	rateAt time.Time
+	// saved holds the bounds cleared by DisableBounds
+	saved *savedBounds
+	// draining is set while MaxEvictPerSet defers evictions
+	draining bool
+	// top keys at the last HotSetChanges
+	hotSet map[string]bool
+	// keys WriteThrough.Load did not find, and until when, see
+	// NegativeTTL
+	negatives      map[string]time.Time
+	negativesSwept int
+	// loading holds the WriteThrough loads in progress by key
+	loading map[string]*pendingLoad
+	// warmup progress
+	ops         int64
+	warmupStart time.Time
+	warm        bool
+	// pinned counts pinned entries
+	pinned int
+	// prios counts entries per non-zero priority class
+	prios       map[int]int
+	prefixes    map[string]*PrefixStats
+	pressure    func() float64
+	history     []EvictionRecord
+	historyNext int
+	historyFull bool
+	now         func() time.Time
+	sketch      *sketch
+}
+
+// WriteThrough connects a Cache to a backing store.  Both hooks are
+// optional.
+type WriteThrough struct {
+	// Load is called by Get on a miss.  If it returns true, the value
+	// is cached as clean and returned, unless the key was written
+	// while Load ran, in which case the written value wins.  Load runs
+	// without the cache lock, so other operations proceed meanwhile;
+	// concurrent misses on the same key wait for a single call.
+	Load func(key string) (interface{}, bool)
+	// Write is called under the cache lock before the cache is updated
+	// by Set, Put, TrySet and friends, so a slow Write stalls every
+	// other caller for its duration.  On error the cache is left
+	// unchanged; Put and TrySet report the failure, Set drops it.
+	// Entries that were written are clean and are not sent on
+	// WriteBackChannel or EvictionChannel.
+	Write func(key string, value interface{}) error
+	// LoadCtx and WriteCtx, if set, take precedence over Load and
+	// Write and receive the context passed to GetCtx and SetCtx, or
+	// context.Background() for the plain methods.
+	LoadCtx  func(ctx context.Context, key string) (interface{}, bool)
+	WriteCtx func(ctx context.Context, key string, value interface{}) error
+	// If LoadTTL > 0, loaded values expire after that long.  If
+	// NegativeTTL > 0, a key the loader did not find is remembered as
+	// absent for that long: Gets for it miss without calling the
+	// loader again until it lapses or the key is written.
+	LoadTTL     time.Duration
+	NegativeTTL time.Duration
+}
+
+func (w *WriteThrough) loads() bool {
+	return w.Load != nil || w.LoadCtx != nil
+}
+
+func (w *WriteThrough) writes() bool {
+	return w.Write != nil || w.WriteCtx != nil
+}
+
+func (w *WriteThrough) write(ctx context.Context, key string, value interface{}) error {
+	if w.WriteCtx != nil {
+		return w.WriteCtx(ctx, key, value)
+	}
+	if w.Write != nil {
+		return w.Write(key, value)
+	}
+	return nil
+}
+
+func (w *WriteThrough) load(ctx context.Context, key string) (interface{}, bool) {
+	if w.LoadCtx != nil {
+		return w.LoadCtx(ctx, key)
+	}
+	if w.Load != nil {
+		return w.Load(key)
+	}
+	return nil, false
+}
+
+// CacheStats holds counters accumulated over the life of a Cache.
+type CacheStats struct {
+	// Hits and Misses count Gets, a miss that WriteThrough.Load
+	// fills included.
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// BytesEvicted is the total cost of evicted entries, as reported
+	// by Sizer.
+	BytesEvicted int64
+	// DirtyEvictions counts evicted entries that were never persisted.
+	DirtyEvictions int64
+	// DroppedEvictions counts evictions not sent on EvictionChannel
+	// because EvictSendTimeout expired.
+	DroppedEvictions int64
+	// SkippedEvictions counts evictions SyncEvictAboveDirty did not
+	// send because EvictionChannel was full.
+	SkippedEvictions int64
+	// LastEvictBuckets and LastEvictScanned are the number of frequency
+	// buckets traversed and entries examined by the most recent
+	// Evict or automatic eviction.
+	LastEvictBuckets int
+	LastEvictScanned int
+	// SetsSinceEviction counts Sets since automatic eviction last ran,
+	// and LastEvictionInterval the Sets between its last two runs.
+	// An interval near 1 means almost every Set evicts, and that
+	// LowerBound leaves too little headroom below UpperBound.
+	SetsSinceEviction    int64
+	LastEvictionInterval int64
+}
+
+type cacheEntry struct {
+	key      string
+	value    interface{}
+	freqNode *list.Element
+	// neighbours within the frequency bucket
+	prev, next *cacheEntry
+	persisted  bool
+	expireAt   time.Time
+	createdAt  time.Time
+	// insertion sequence number, see TieBreakFIFO
+	seq uint64
+	// lastAccess is updated on every increment
+	lastAccess time.Time
+	// access count, for LFUDA
+	hits int
+	// priority class, see SetWithPriority
+	prio   int
+	pinned bool
+	// meta is caller bookkeeping, kept across overwrites
+	meta interface{}
+	// cost as reported by Sizer
+	cost int64
+	// checksum of value at Set time, if sealed
+	checksum uint64
+	sealed   bool
+}
+
+type listEntry struct {
+	// entries at this frequency, in the order they arrived, so the
+	// front is the least recently used
+	entries entryList
+	freq    int
+}
+
+// entryList is an intrusive doubly linked list of cache entries.
+// Unlike container/list it does not allocate on insert.
+type entryList struct {
+	head, tail *cacheEntry
+	len        int
+}
+
+func (l *entryList) Front() *cacheEntry {
+	return l.head
+}
+
+func (l *entryList) Len() int {
+	return l.len
+}
+
+func (l *entryList) PushBack(e *cacheEntry) {
+	e.prev, e.next = l.tail, nil
+	if l.tail != nil {
+		l.tail.next = e
+	} else {
+		l.head = e
+	}
+	l.tail = e
+	l.len++
+}
+
+func (l *entryList) Remove(e *cacheEntry) {
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		l.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		l.tail = e.prev
+	}
+	e.prev, e.next = nil, nil
+	l.len--
+}
+
+func New() *Cache {
+	c := new(Cache)
+	c.reset(0)
+	c.IncrementOnSet = true
+	c.lock = new(sync.Mutex)
+	c.now = time.Now
+	return c
+}
+
+// reset empties the cache, sizing the index for n entries.
+func (c *Cache) reset(n int) {
+	c.values = make(map[string]*cacheEntry, n)
+	c.freqs = list.New()
+	c.buckets = make(map[int]*list.Element)
+	c.len = 0
+	c.age = 0
+	c.dirty = 0
+	c.cost = 0
+	c.pinned = 0
+	c.prios = nil
+}
+
+// NewUnlocked returns a Cache that does no locking at all.
+//
+// It is NOT safe for concurrent use.  Only use it where access is
+// already serialized, e.g. from a single goroutine or an event loop.
+func NewUnlocked() *Cache {
+	c := New()
+	c.lock = noLock{}
+	return c
+}
+
+// noLock is a sync.Locker that does nothing.
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
+
+func (c *Cache) Get(key string) interface{} {
+	return c.GetCtx(context.Background(), key)
+}
+
+// GetCtx is like Get, passing ctx on to WriteThrough.LoadCtx.  The
+// context only carries request-scoped values such as trace spans; the
+// in-memory lookup is never cancelled.
+func (c *Cache) GetCtx(ctx context.Context, key string) interface{} {
+	c.lock.Lock()
+	defer c.unlock()
+	value, _ := c.get(ctx, key)
+	return value
+}
+
+// GetOrError is like Get, but returns ErrNotFound on a miss.
+func (c *Cache) GetOrError(key string) (interface{}, error) {
+	c.lock.Lock()
+	defer c.unlock()
+	if value, ok := c.get(context.Background(), key); ok {
+		return value, nil
+	}
+	return nil, ErrNotFound
+}
+
+// GetAndRefresh is like Get, but also pushes the entry's deadline back
+// by extend, as for renewing a lease.  Entries without a deadline keep
+// none.  A missing or expired key is not loaded through WriteThrough.
+func (c *Cache) GetAndRefresh(key string, extend time.Duration) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	e, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	if !e.expireAt.IsZero() {
+		e.expireAt = e.expireAt.Add(extend)
+	}
+	c.increment(e)
+	return c.exposed(e), true
+}
+
+// GetWithFreq is like Get, but also returns the entry's frequency
+// after the read has been counted.  A value loaded through
+// WriteThrough reports the frequency it was cached at, or 0 if it was
+// not admitted.
+func (c *Cache) GetWithFreq(key string) (value interface{}, freq int, ok bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	if value, ok = c.get(context.Background(), key); !ok {
+		return nil, 0, false
+	}
+	if e, found := c.values[key]; found {
+		freq = e.freqNode.Value.(*listEntry).freq
+	}
+	return value, freq, true
+}
+
+// FrequencyOf returns the frequency of key without counting an
+// access, or false if it is not present.
+func (c *Cache) FrequencyOf(key string) (int, bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, ok := c.lookup(key); ok {
+		return e.freqNode.Value.(*listEntry).freq, true
+	}
+	return 0, false
+}
+
+// FrequenciesOf returns the frequencies of the present keys in keys,
+// under a single lock acquisition and without counting accesses.
+func (c *Cache) FrequenciesOf(keys []string) map[string]int {
+	c.lock.Lock()
+	defer c.unlock()
+	freqs := make(map[string]int, len(keys))
+	for _, key := range keys {
+		if e, ok := c.lookup(key); ok {
+			freqs[key] = e.freqNode.Value.(*listEntry).freq
+		}
+	}
+	return freqs
+}
+
+func (c *Cache) get(ctx context.Context, key string) (interface{}, bool) {
+	c.countOp()
+	if c.sketch != nil {
+		c.sketch.add(key)
+	}
+	if e, ok := c.lookup(key); ok {
+		c.stats.Hits++
+		c.countHitRatio(true)
+		if c.prefixes != nil {
+			c.countPrefix(key, countHit)
+		}
+		c.verify(e)
+		c.increment(e)
+		return c.exposed(e), true
+	}
+	c.stats.Misses++
+	c.countHitRatio(false)
+	if c.prefixes != nil {
+		c.countPrefix(key, countMiss)
+	}
+	return c.load(ctx, key)
+}
+
+// GetBatchRanked looks up each key, counting every read, and returns
+// the entries found sorted by their resulting frequency, hottest first.
+// A key repeated in keys is counted each time but returned once.
+func (c *Cache) GetBatchRanked(keys []string) []Eviction {
+	c.lock.Lock()
+	defer c.unlock()
+	found := make([]*cacheEntry, 0, len(keys))
+	seen := make(map[*cacheEntry]bool, len(keys))
+	for _, key := range keys {
+		if c.sketch != nil {
+			c.sketch.add(key)
+		}
+		if e, ok := c.lookup(key); ok {
+			c.increment(e)
+			if !seen[e] {
+				seen[e] = true
+				found = append(found, e)
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].freqNode.Value.(*listEntry).freq > found[j].freqNode.Value.(*listEntry).freq
+	})
+	ranked := make([]Eviction, len(found))
+	for i, e := range found {
+		ranked[i] = Eviction{Key: e.key, Value: c.exposed(e)}
+	}
+	return ranked
+}
+
+func (c *Cache) Set(key string, value interface{}) {
+	c.SetCtx(context.Background(), key, value)
+}
+
+// SetCtx is like Set, passing ctx on to WriteThrough.WriteCtx.
+func (c *Cache) SetCtx(ctx context.Context, key string, value interface{}) {
+	c.lock.Lock()
+	defer c.unlock()
+	c.store(ctx, key, value)
+}
+
+// Put is like Set, but returns the error from WriteThrough.Write.
+func (c *Cache) Put(key string, value interface{}) error {
+	c.lock.Lock()
+	defer c.unlock()
+	_, err := c.store(context.Background(), key, value)
+	return err
+}
+
+// SetChecked is like Set, but returns the error from ValueValidator,
+// or from WriteThrough.Write as Put does.
+func (c *Cache) SetChecked(key string, value interface{}) error {
+	return c.Put(key, value)
+}
+
+func (c *Cache) validate(value interface{}) error {
+	if c.ValueValidator != nil {
+		return c.ValueValidator(value)
+	}
+	return nil
+}
+
+// SetWithDeadline is like Set, but the entry expires at deadline.
+// An expired entry is treated as absent and removed when next looked up.
+func (c *Cache) SetWithDeadline(key string, value interface{}, deadline time.Time) {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, _ := c.store(context.Background(), key, value); e != nil {
+		e.expireAt = deadline
+	}
+}
+
+// TrySet is like Set but reports whether the value was stored.  It
+// returns false if the key is new and the cache is at HardCap, or if
+// WriteThrough.Write fails.
+func (c *Cache) TrySet(key string, value interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	e, err := c.store(context.Background(), key, value)
+	return e != nil && err == nil
+}
+
+// SetAtomic stores all items or none of them.  It only commits if the
+// new keys fit under HardCap and UpperBound, and the resulting cost
+// under MaxBytes, without evicting anything, so no item can be
+// rejected or evicted by its own batch.  It reports whether the items
+// were stored.  Every value is checked by ValueValidator first.  With
+// WriteThrough.Write, all items are written before any is cached, and
+// a failure aborts the batch, possibly leaving the backing store
+// partially written.
+func (c *Cache) SetAtomic(items map[string]interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	for _, value := range items {
+		if c.validate(value) != nil {
+			return false
+		}
+	}
+	added := 0
+	for key := range items {
+		if _, ok := c.lookup(key); !ok {
+			added++
+		}
+	}
+	if c.HardCap > 0 && c.len+added > c.HardCap {
+		return false
+	}
+	if upper, _ := c.bounds(); upper > 0 && c.len+added > upper {
+		return false
+	}
+	if maxBytes := c.maxBytes(); maxBytes > 0 && c.Sizer != nil {
+		cost := c.cost
+		for key, value := range items {
+			if e, ok := c.values[key]; ok {
+				cost -= e.cost
+			}
+			var n int64
+			c.call(func() { n = c.Sizer(key, c.encode(value)) })
+			cost += n
+		}
+		if cost > maxBytes {
+			return false
+		}
+	}
+	if c.WriteThrough.writes() {
+		for key, value := range items {
+			if err := c.WriteThrough.write(context.Background(), key, value); err != nil {
+				return false
+			}
+		}
+	}
+	for key, value := range items {
+		if e := c.set(key, value); e != nil && c.WriteThrough.writes() {
+			c.setPersisted(e, true)
+		}
+	}
+	return true
+}
+
+// Swap atomically replaces the whole contents of the cache with
+// newContents, all at InitialFrequency, and returns the old contents
+// coldest first.  Bounds are not enforced and nothing is sent on the
+// channels.
+func (c *Cache) Swap(newContents map[string]interface{}) []Eviction {
+	c.lock.Lock()
+	defer c.unlock()
+	old := make([]Eviction, 0, c.len)
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		for entry := place.Value.(*listEntry).entries.Front(); entry != nil; entry = entry.next {
+			old = append(old, Eviction{Key: entry.key, Value: c.exposed(entry)})
+		}
+	}
+	c.reset(len(newContents))
+	now := c.now()
+	for key, value := range newContents {
+		e := &cacheEntry{key: key, value: c.encode(value), createdAt: now, lastAccess: now, seq: c.nextSeq()}
+		c.resize(e)
+		c.values[key] = e
+		c.place(e, c.initialFrequency())
+		c.added(e)
+	}
+	c.selfCheck()
+	return old
+}
+
+// SwapValue stores value for key and returns the previous value, or
+// false if the key was not present.  The entry's frequency is bumped
+// and it is marked dirty, as with Set.  If ValueValidator or
+// WriteThrough.Write fails, or a new key is not admitted, the old
+// value is kept and the error, or ErrNotStored, is returned.
+func (c *Cache) SwapValue(key string, value interface{}) (old interface{}, existed bool, err error) {
+	c.lock.Lock()
+	defer c.unlock()
+	e, existed := c.lookup(key)
+	if existed {
+		old = c.exposed(e)
+	}
+	if e, err = c.store(context.Background(), key, value); err != nil {
+		return old, existed, err
+	}
+	if e == nil {
+		return old, existed, ErrNotStored
+	}
+	if existed && !c.IncrementOnSet {
+		c.increment(e)
+	}
+	return old, existed, nil
+}
+
+// Update atomically replaces the value for key with fn(old) and bumps
+// its frequency, keeping any deadline.  It returns false, without
+// calling fn, if the key is not present.  fn runs under the cache lock
+// and must not call back into the cache.  If WriteThrough.Write fails,
+// the old value is kept.
+func (c *Cache) Update(key string, fn func(old interface{}) interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	e, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	c.update(e, fn(c.exposed(e)))
+	return true
+}
+
+// Incr atomically adds delta to the int64 stored at key and bumps its
+// frequency, storing delta if the key is not present.  It returns the
+// new total and whether the key was created, or ErrNotInt64 if the
+// existing value is not an int64 and ErrNotStored if a new key could
+// not be cached.
+func (c *Cache) Incr(key string, delta int64) (int64, bool, error) {
+	c.lock.Lock()
+	defer c.unlock()
+	e, ok := c.lookup(key)
+	if !ok {
+		e, err := c.store(context.Background(), key, delta)
+		if err != nil {
+			return 0, false, err
+		}
+		if e == nil {
+			return 0, false, ErrNotStored
+		}
+		return delta, true, nil
+	}
+	n, ok := c.exposed(e).(int64)
+	if !ok {
+		return 0, false, ErrNotInt64
+	}
+	if err := c.update(e, n+delta); err != nil {
+		return 0, false, err
+	}
+	return n + delta, false, nil
+}
+
+// update replaces the value of an existing entry in place, keeping its
+// deadline, and bumps its frequency.
+func (c *Cache) update(e *cacheEntry, value interface{}) error {
+	if err := c.validate(value); err != nil {
+		return err
+	}
+	if err := c.WriteThrough.write(context.Background(), e.key, value); err != nil {
+		return err
+	}
+	c.replaced(e)
+	e.value = c.encode(value)
+	c.setPersisted(e, c.WriteThrough.writes())
+	c.seal(e)
+	c.resize(e)
+	c.increment(e)
+	c.enforceBounds()
+	return nil
+}
+
+// replaced reports the current value of e to OnReplace before it is
+// overwritten.
+func (c *Cache) replaced(e *cacheEntry) {
+	if c.OnReplace != nil {
+		old := Eviction{Key: e.key, Value: c.exposed(e)}
+		c.call(func() { c.OnReplace(old) })
+	}
+}
+
+// LoadOrStore returns the existing value for the key if present.
+// Otherwise it stores and returns the given value. The loaded result
+// is true if the value was loaded, false if stored.
+func (c *Cache) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, ok := c.lookup(key); ok {
+		c.increment(e)
+		return c.exposed(e), true
+	}
+	c.store(context.Background(), key, value)
+	return value, false
+}
+
+// GetOrCreate returns the value for key if present, counting the
+// access.  Otherwise it stores and returns create(key).  create runs
+// under the cache lock and must not call back into the cache.
+func (c *Cache) GetOrCreate(key string, create func(key string) interface{}) interface{} {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, ok := c.lookup(key); ok {
+		c.increment(e)
+		return c.exposed(e)
+	}
+	value := create(key)
+	c.store(context.Background(), key, value)
+	return value
+}
+
+// set stores value without consulting WriteThrough and returns the
+// entry, or nil if it was not admitted.
+func (c *Cache) set(key string, value interface{}) *cacheEntry {
+	c.countOp()
+	c.stats.SetsSinceEviction++
+	if c.negatives != nil {
+		delete(c.negatives, key)
+	}
+	if c.sketch != nil {
+		c.sketch.add(key)
+	}
+	if e, ok := c.values[key]; ok {
+		// value already exists for key.  overwrite
+		c.replaced(e)
+		e.value = c.encode(value)
+		c.setPersisted(e, false)
+		e.expireAt = time.Time{}
+		c.seal(e)
+		c.resize(e)
+		if c.IncrementOnSet {
+			c.increment(e)
+		}
+	} else {
+		// value doesn't exist.  insert
+		if !c.admit(key) {
+			return nil
+		}
+		if c.HardCap > 0 && c.len >= c.HardCap {
+			if !c.HardCapEvict {
+				return nil
+			}
+			c.evict(c.len-c.HardCap+1, ReasonCapacity)
+			if c.len >= c.HardCap {
+				// the rest is pinned
+				return nil
+			}
+		}
+		e = new(cacheEntry)
+		e.key = key
+		e.value = c.encode(value)
+		e.createdAt = c.now()
+		e.seq = c.nextSeq()
+		c.seal(e)
+		c.resize(e)
+		c.values[key] = e
+		c.increment(e)
+		c.added(e)
+	}
+	c.enforceBounds()
+	c.checkThresholds()
+	c.selfCheck()
+	// bounds mgmt may have evicted the new entry itself
+	return c.values[key]
+}
+
+// enforceBounds evicts down to LowerBound if len exceeds UpperBound,
+// and then until the total cost is within MaxBytes.
+func (c *Cache) enforceBounds() {
+	evictions, draining := c.stats.Evictions, c.draining
+	defer func() {
+		if c.stats.Evictions > evictions {
+			c.boundEvicted(draining || c.draining)
+		}
+	}()
+	upper, lower := c.bounds()
+	if upper > 0 && (c.len > upper || c.draining && c.len > lower) {
+		if c.draining || !c.warming() || c.len > c.warmupCap() {
+			n := c.len - lower
+			if max := c.MaxEvictPerSet; max > 0 {
+				if max < 2 {
+					max = 2
+				}
+				if n > max {
+					n = max
+				}
+			}
+			c.evict(n, ReasonCapacity)
+			c.draining = c.MaxEvictPerSet > 0 && c.len > lower
+		}
+	}
+	maxBytes := c.maxBytes()
+	for maxBytes > 0 && c.cost > maxBytes && c.len > 0 {
+		if c.evict(1, ReasonCapacity) == 0 {
+			// the rest is pinned
+			break
+		}
+	}
+}
+
+// boundEvicted updates the eviction interval stats after automatic
+// eviction.  OnOscillation is not called for a MaxEvictPerSet drain.
+func (c *Cache) boundEvicted(draining bool) {
+	interval := c.stats.SetsSinceEviction
+	c.stats.LastEvictionInterval = interval
+	c.stats.SetsSinceEviction = 0
+	if c.OnOscillation != nil && !draining && interval < int64(c.OscillationSets) {
+		c.call(func() { c.OnOscillation(interval) })
+	}
+}
+
+// store writes value through to the backing store, if configured, and
+// then sets it.  Entries written through are clean.  If Write fails the
+// cache is left unchanged.
+func (c *Cache) store(ctx context.Context, key string, value interface{}) (*cacheEntry, error) {
+	if err := c.validate(value); err != nil {
+		return nil, err
+	}
+	if err := c.WriteThrough.write(ctx, key, value); err != nil {
+		return nil, err
+	}
+	e := c.set(key, value)
+	if e != nil && c.WriteThrough.writes() {
+		c.setPersisted(e, true)
+	}
+	return e, nil
+}
+
+// load fetches a missing key from the backing store, if configured,
+// and caches it.  The lock is released while the loader runs, and a
+// miss on a key that is already being loaded waits for that load
+// instead of starting another.
+func (c *Cache) load(ctx context.Context, key string) (interface{}, bool) {
+	if !c.WriteThrough.loads() || c.negative(key) {
+		return nil, false
+	}
+	if l, ok := c.loading[key]; ok {
+		c.unlock()
+		<-l.done
+		c.lock.Lock()
+		return l.value, l.ok
+	}
+	if c.loading == nil {
+		c.loading = make(map[string]*pendingLoad)
+	}
+	l := &pendingLoad{done: make(chan struct{})}
+	c.loading[key] = l
+	c.unlock()
+	func() {
+		defer func() {
+			c.lock.Lock()
+			delete(c.loading, key)
+			close(l.done)
+		}()
+		l.value, l.ok = c.WriteThrough.load(ctx, key)
+	}()
+	if e, ok := c.lookup(key); ok {
+		// written while loading, which is newer
+		l.value, l.ok = c.exposed(e), true
+		return l.value, true
+	}
+	if !l.ok {
+		if c.WriteThrough.NegativeTTL > 0 {
+			c.addNegative(key)
+		}
+		return nil, false
+	}
+	if e := c.set(key, l.value); e != nil {
+		c.setPersisted(e, true)
+		if c.WriteThrough.LoadTTL > 0 {
+			e.expireAt = c.now().Add(c.WriteThrough.LoadTTL)
+		}
+	}
+	return l.value, true
+}
+
+// pendingLoad is a WriteThrough load in progress.  value and ok are
+// set before done is closed.
+type pendingLoad struct {
+	done  chan struct{}
+	value interface{}
+	ok    bool
+}
+
+func (c *Cache) encode(value interface{}) interface{} {
+	if c.Encode != nil {
+		return c.Encode(value)
+	}
+	return value
+}
+
+// exposed returns the value of e as callers see it.
+func (c *Cache) exposed(e *cacheEntry) interface{} {
+	if c.Decode != nil {
+		return c.Decode(e.value)
+	}
+	return e.value
+}
+
+// lookup returns the entry for key, removing it first if it has expired.
+func (c *Cache) lookup(key string) (*cacheEntry, bool) {
+	e, ok := c.values[key]
+	if ok && c.expired(e) {
+		c.expire(e)
+		return nil, false
+	}
+	return e, ok
+}
+
+// expire removes an entry past its deadline, reporting it on
+// ExpirationChannel.
+func (c *Cache) expire(e *cacheEntry) {
+	c.record(e, ReasonExpired)
+	if c.ExpirationChannel != nil {
+		c.ExpirationChannel <- c.emitted(e)
+	}
+	c.delete(e)
+}
+
+func (c *Cache) expired(e *cacheEntry) bool {
+	return !e.expireAt.IsZero() && !c.now().Before(e.expireAt)
+}
+
+func (c *Cache) Delete(key string) {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, ok := c.values[key]; ok {
+		c.delete(e)
+		c.checkThresholds()
+	}
+}
+
+// DeleteMany deletes every present key in keys under a single lock
+// acquisition and returns the number deleted.
+func (c *Cache) DeleteMany(keys []string) int {
+	c.lock.Lock()
+	defer c.unlock()
+	var n int
+	for i, key := range keys {
+		c.pause(i)
+		if e, ok := c.values[key]; ok {
+			c.delete(e)
+			n++
+		}
+	}
+	if n > 0 {
+		c.checkThresholds()
+	}
+	return n
+}
+
+func (c *Cache) delete(entry *cacheEntry) {
+	c.cost -= entry.cost
+	if entry.prio != 0 {
+		c.setPriority(entry, 0)
+	}
+	if entry.pinned {
+		c.pinned--
+	}
+	delete(c.values, entry.key)
+	c.remEntry(entry.freqNode, entry)
+	c.len--
+	if !entry.persisted {
+		c.dirty--
+	}
+	c.selfCheck()
+}
+
+// Clear removes every entry without reporting any of them on the
+// channels.  Settings and Stats are kept.
+func (c *Cache) Clear() {
+	c.lock.Lock()
+	defer c.unlock()
+	c.reset(0)
+}
+
+// added accounts for an entry just inserted into the cache.
+func (c *Cache) added(e *cacheEntry) {
+	c.len++
+	c.filled = true
+	if !e.persisted {
+		c.dirty++
+	}
+}
+
+func (c *Cache) setPersisted(e *cacheEntry, persisted bool) {
+	if e.persisted == persisted {
+		return
+	}
+	e.persisted = persisted
+	if persisted {
+		c.dirty--
+	} else {
+		c.dirty++
+	}
+}
+
+// pause briefly releases the lock before the i-th item of a bulk
+// operation if BulkChunk items have been processed since the last
+// pause.
+func (c *Cache) pause(i int) {
+	if c.BulkChunk > 0 && i > 0 && i%c.BulkChunk == 0 {
+		c.unlock()
+		runtime.Gosched()
+		c.lock.Lock()
+	}
+}
+
+// unlock releases the lock at the end of an operation, first calling
+// OnEmpty if the operation left a cache that held entries empty.
+// Checking here rather than on every delete skips caches that are
+// only empty halfway through an operation, e.g. HardCapEvict making
+// room for a new key.
+func (c *Cache) unlock() {
+	defer c.lock.Unlock()
+	if c.filled && c.len == 0 {
+		c.filled = false
+		if c.OnEmpty != nil {
+			c.call(c.OnEmpty)
+		}
+	}
+}
+
+// Rename moves the entry for oldKey to newKey, keeping its value,
+// frequency, deadline and dirty state.  Nothing is written through.
+// If newKey is already present, its entry is reported to OnReplace
+// and deleted first if RenameOverwrites is set, and otherwise Rename
+// fails.  It returns false if oldKey is not present or the rename
+// failed.
+func (c *Cache) Rename(oldKey, newKey string) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	e, ok := c.lookup(oldKey)
+	if !ok {
+		return false
+	}
+	if oldKey == newKey {
+		return true
+	}
+	if existing, ok := c.lookup(newKey); ok {
+		if !c.RenameOverwrites {
+			return false
+		}
+		c.replaced(existing)
+		c.delete(existing)
+	}
+	delete(c.values, oldKey)
+	e.key = newKey
+	c.values[newKey] = e
+	c.resize(e)
+	return true
+}
+
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.len
+}
+
+// CurrentCost returns the total cost of all entries as reported by
+// Sizer, or 0 if no Sizer is set.
+func (c *Cache) CurrentCost() int64 {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.cost
+}
+
+// CurrentBytes is CurrentCost for a Sizer that measures bytes, as
+// bounded by MaxBytes.
+func (c *Cache) CurrentBytes() int64 {
+	return c.CurrentCost()
+}
+
+// resize recomputes the cost of e after its value changed.
+func (c *Cache) resize(e *cacheEntry) {
+	c.cost -= e.cost
+	e.cost = 0
+	if c.Sizer != nil {
+		c.call(func() { e.cost = c.Sizer(e.key, e.value) })
+	}
+	c.cost += e.cost
+}
+
+// Utilization returns how full the cache is relative to whichever of
+// its bounds is closest to being reached: the larger of len over the
+// effective UpperBound and CurrentCost over MaxBytes.  Disabled bounds
+// are ignored, and it returns 0 if neither is enabled.
+func (c *Cache) Utilization() float64 {
+	c.lock.Lock()
+	defer c.unlock()
+	var u float64
+	if upper, _ := c.bounds(); upper > 0 {
+		u = float64(c.len) / float64(upper)
+	}
+	if maxBytes := c.maxBytes(); maxBytes > 0 {
+		u = math.Max(u, float64(c.cost)/float64(maxBytes))
+	}
+	return u
+}
+
+// BucketCount returns the number of distinct frequencies in the cache.
+func (c *Cache) BucketCount() int {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.freqs.Len()
+}
+
+// MinFrequency returns the lowest frequency in the cache, or false
+// if it is empty.
+func (c *Cache) MinFrequency() (int, bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	if place := c.freqs.Front(); place != nil {
+		return place.Value.(*listEntry).freq, true
+	}
+	return 0, false
+}
+
+// MaxFrequency returns the highest frequency in the cache, or false
+// if it is empty.
+func (c *Cache) MaxFrequency() (int, bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	if place := c.freqs.Back(); place != nil {
+		return place.Value.(*listEntry).freq, true
+	}
+	return 0, false
+}
+
+// mapEntryOverhead approximates the per-entry cost of a Go map beyond
+// its keys and values: the tophash byte, bucket slack and overflow
+// pointers.
+const mapEntryOverhead = 16
+
+// StructuralOverheadBytes estimates the memory taken by the cache's
+// own bookkeeping: the entry structs, the frequency list and both
+// maps, but not the contents of keys or values.  It is computed from
+// counts and struct sizes, so it is cheap but approximate.
+func (c *Cache) StructuralOverheadBytes() int64 {
+	c.lock.Lock()
+	defer c.unlock()
+	perEntry := unsafe.Sizeof(cacheEntry{}) +
+		unsafe.Sizeof("") + unsafe.Sizeof(&cacheEntry{}) + mapEntryOverhead
+	perBucket := unsafe.Sizeof(list.Element{}) + unsafe.Sizeof(listEntry{}) +
+		unsafe.Sizeof(0) + unsafe.Sizeof(&list.Element{}) + mapEntryOverhead
+	return int64(c.len)*int64(perEntry) + int64(c.freqs.Len())*int64(perBucket)
+}
+
+// KeysAtFrequency returns the keys whose frequency is exactly freq,
+// least recently used first.
+func (c *Cache) KeysAtFrequency(freq int) []string {
+	c.lock.Lock()
+	defer c.unlock()
+	keys := []string{}
+	if place, ok := c.buckets[freq]; ok {
+		for e := place.Value.(*listEntry).entries.Front(); e != nil; e = e.next {
+			if !c.expired(e) {
+				keys = append(keys, e.key)
+			}
+		}
+	}
+	return keys
+}
+
+// Entry is a cache entry along with its frequency.
+type Entry struct {
+	Key   string
+	Value interface{}
+	Freq  int
 }
 
-type Cache struct {
-	// If len > UpperBound, cache will automatically evict
-	// down to LowerBound.  If either value is 0, this behavior
-	// is disabled.
-	UpperBound       int
-	LowerBound       int
-	values           map[string]*cacheEntry
-	freqs            *list.List
-	len              int
-	lock             *sync.Mutex
-	EvictionChannel  chan<- Eviction
-	WriteBackChannel chan<- Eviction
+// Entries returns a snapshot of all entries, in no particular order.
+// Unlike SortedEntries it does not sort, so it costs O(n).
+func (c *Cache) Entries() []Entry {
+	c.lock.Lock()
+	defer c.unlock()
+	entries := make([]Entry, 0, c.len)
+	for _, e := range c.values {
+		if !c.expired(e) {
+			entries = append(entries, Entry{Key: e.key, Value: c.exposed(e), Freq: e.freqNode.Value.(*listEntry).freq})
+		}
+	}
+	return entries
 }
 
-type cacheEntry struct {
-	key       string
-	value     interface{}
-	freqNode  *list.Element
-	persisted bool
+// SortedEntries returns a snapshot of all entries, ordered by
+// frequency and then by key, for exports and comparisons that must not
+// depend on map order.  It copies and sorts every entry under the
+// lock, so it costs O(n log n).
+func (c *Cache) SortedEntries() []Eviction {
+	c.lock.Lock()
+	defer c.unlock()
+	entries := make([]*cacheEntry, 0, c.len)
+	for _, e := range c.values {
+		if !c.expired(e) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		fi := entries[i].freqNode.Value.(*listEntry).freq
+		fj := entries[j].freqNode.Value.(*listEntry).freq
+		if fi != fj {
+			return fi < fj
+		}
+		return entries[i].key < entries[j].key
+	})
+	sorted := make([]Eviction, len(entries))
+	for i, e := range entries {
+		sorted[i] = Eviction{Key: e.key, Value: c.exposed(e)}
+	}
+	return sorted
 }
 
-type listEntry struct {
-	entries map[*cacheEntry]byte
-	freq    int
+// TotalFrequency returns the sum of the frequencies of all entries.
+// Divided by Len it gives the average access count.
+func (c *Cache) TotalFrequency() int64 {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.totalFrequency()
 }
 
-func New() *Cache {
-	c := new(Cache)
-	c.values = make(map[string]*cacheEntry)
-	c.freqs = list.New()
-	c.lock = new(sync.Mutex)
-	return c
+func (c *Cache) totalFrequency() int64 {
+	var total int64
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		li := place.Value.(*listEntry)
+		total += int64(li.freq) * int64(li.entries.Len())
+	}
+	return total
 }
 
-func (c *Cache) Get(key string) interface{} {
+func (c *Cache) Stats() CacheStats {
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	if e, ok := c.values[key]; ok {
-		c.increment(e)
-		return e.value
+	defer c.unlock()
+	return c.stats
+}
+
+// TotalBytesEvicted returns the total cost, as reported by Sizer, of
+// every entry evicted so far.  It is Stats().BytesEvicted.
+func (c *Cache) TotalBytesEvicted() int64 {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.stats.BytesEvicted
+}
+
+// DetailedStats extends CacheStats with the distribution of entry
+// frequencies.
+type DetailedStats struct {
+	CacheStats
+	// Frequencies at or below which 50%, 90% and 99% of the entries
+	// lie, or 0 for an empty cache.
+	P50, P90, P99 int
+}
+
+// DetailedStats returns Stats along with frequency percentiles.  It
+// walks the frequency buckets, so unlike Stats it is not O(1).
+func (c *Cache) DetailedStats() DetailedStats {
+	c.lock.Lock()
+	defer c.unlock()
+	ds := DetailedStats{CacheStats: c.stats}
+	targets := []struct {
+		q float64
+		p *int
+	}{{0.5, &ds.P50}, {0.9, &ds.P90}, {0.99, &ds.P99}}
+	var seen int
+	for place := c.freqs.Front(); place != nil && len(targets) > 0; place = place.Next() {
+		li := place.Value.(*listEntry)
+		seen += li.entries.Len()
+		for len(targets) > 0 && float64(seen) >= targets[0].q*float64(c.len) {
+			*targets[0].p = li.freq
+			targets = targets[1:]
+		}
 	}
-	return nil
+	return ds
 }
 
-func (c *Cache) Set(key string, value interface{}) {
+func (c *Cache) Evict(count int) int {
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	if e, ok := c.values[key]; ok {
-		// value already exists for key.  overwrite
-		e.value = value
-		e.persisted = false
-		c.increment(e)
-	} else {
-		// value doesn't exist.  insert
-		e = new(cacheEntry)
-		e.key = key
-		e.value = value
-		c.values[key] = e
-		c.increment(e)
-		c.len++
-		// bounds mgmt
-		if c.UpperBound > 0 && c.LowerBound > 0 {
-			if c.len > c.UpperBound {
-				c.evict(c.len - c.LowerBound)
+	defer c.unlock()
+	return c.evict(count, ReasonManual)
+}
+
+// EvictBelowMean evicts every entry whose frequency is below the
+// average frequency of the cache and returns the number evicted.
+func (c *Cache) EvictBelowMean() int {
+	c.lock.Lock()
+	defer c.unlock()
+	if c.len == 0 {
+		return 0
+	}
+	mean := float64(c.totalFrequency()) / float64(c.len)
+	var evicted int
+	for place := c.freqs.Front(); place != nil; {
+		li := place.Value.(*listEntry)
+		if float64(li.freq) >= mean {
+			break
+		}
+		next := place.Next()
+		for entry := li.entries.Front(); entry != nil; {
+			victim := entry
+			entry = entry.next
+			if victim.pinned {
+				continue
 			}
+			c.evictEntry(victim, ReasonManual)
+			evicted++
 		}
+		place = next
 	}
+	return evicted
 }
 
-func (c *Cache) Delete(key string) {
+// EvictMFU evicts up to count of the most frequently used entries and
+// returns the number evicted.
+func (c *Cache) EvictMFU(count int) int {
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	if e, ok := c.values[key]; ok {
-		c.delete(e)
+	defer c.unlock()
+	var evicted int
+	for place := c.freqs.Back(); place != nil && evicted < count; {
+		prev := place.Prev()
+		for entry := place.Value.(*listEntry).entries.Front(); entry != nil && evicted < count; {
+			victim := entry
+			entry = entry.next
+			if victim.pinned {
+				continue
+			}
+			c.evictEntry(victim, ReasonManual)
+			evicted++
+		}
+		place = prev
 	}
+	return evicted
 }
 
-func (c *Cache) delete(entry *cacheEntry) {
-	delete(c.values, entry.key)
-	c.remEntry(entry.freqNode, entry)
-	c.len--
+// EvictBytes evicts entries until at least target of cost as reported
+// by Sizer has been freed, and returns the cost freed.  It works
+// through the coldest bucket first like Evict, but within a bucket it
+// takes the largest entries first, trading strict LRU order among
+// equally cold entries for reaching the target with fewer evictions.
+// Without a Sizer it does nothing.
+func (c *Cache) EvictBytes(target int64) int64 {
+	c.lock.Lock()
+	defer c.unlock()
+	if c.Sizer == nil {
+		return 0
+	}
+	var freed int64
+	for _, prio := range c.priorityClasses() {
+		for place := c.freqs.Front(); place != nil && freed < target; {
+			next := place.Next()
+			var candidates []*cacheEntry
+			for e := place.Value.(*listEntry).entries.Front(); e != nil; e = e.next {
+				if e.prio == prio && !e.pinned {
+					candidates = append(candidates, e)
+				}
+			}
+			sort.SliceStable(candidates, func(i, j int) bool {
+				return candidates[i].cost > candidates[j].cost
+			})
+			for _, e := range candidates {
+				if freed >= target {
+					break
+				}
+				freed += e.cost
+				c.evictEntry(e, ReasonManual)
+			}
+			place = next
+		}
+	}
+	return freed
 }
 
-func (c *Cache) Len() int {
+// EvictionPreview returns the entries Evict(count) would remove, in
+// the order it would remove them, without modifying the cache.
+func (c *Cache) EvictionPreview(count int) []Eviction {
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	return c.len
+	defer c.unlock()
+	entries, _, _ := c.victims(count)
+	victims := make([]Eviction, len(entries))
+	for i, entry := range entries {
+		victims[i] = Eviction{Key: entry.key, Value: c.exposed(entry)}
+	}
+	return victims
 }
 
-func (c *Cache) Evict(count int) int {
+// NextVictim returns the entry the next eviction would remove, without
+// modifying the cache.  It is false for an empty cache.
+func (c *Cache) NextVictim() (Eviction, bool) {
 	c.lock.Lock()
-	defer c.lock.Unlock()
-	return c.evict(count)
+	defer c.unlock()
+	if entry := c.victim(); entry != nil {
+		return Eviction{Key: entry.key, Value: c.exposed(entry)}, true
+	}
+	return Eviction{}, false
+}
+
+// LowestScoring returns the entry for which score is lowest, or false
+// if the cache is empty.  It scans every entry under the lock, so it
+// is O(n); it is meant for trying out eviction heuristics, not for
+// hot paths.  score must not call back into the cache.
+func (c *Cache) LowestScoring(score func(key string, value interface{}, freq int, lastAccess time.Time) float64) (Eviction, bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	var lowest *cacheEntry
+	var min float64
+	for _, e := range c.values {
+		if c.expired(e) {
+			continue
+		}
+		v := score(e.key, c.exposed(e), e.freqNode.Value.(*listEntry).freq, e.lastAccess)
+		if lowest == nil || v < min {
+			lowest, min = e, v
+		}
+	}
+	if lowest == nil {
+		return Eviction{}, false
+	}
+	return Eviction{Key: lowest.key, Value: c.exposed(lowest)}, true
 }
 
 func (c *Cache) WriteBack(count int) int {
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	defer c.unlock()
 	return c.persist(count)
 }
 
-func (c *Cache) evict(count int) int {
+// WriteBackChecked is like WriteBack, but returns
+// ErrNoWriteBackChannel if WriteBackChannel is not set.
+func (c *Cache) WriteBackChecked(count int) (int, error) {
+	c.lock.Lock()
+	defer c.unlock()
+	if c.WriteBackChannel == nil {
+		return 0, ErrNoWriteBackChannel
+	}
+	return c.persist(count), nil
+}
+
+// RangeOlderThan calls fn for every entry not accessed within age,
+// stopping early if fn returns false.  fn is called under the lock and
+// must not call back into the cache.
+func (c *Cache) RangeOlderThan(age time.Duration, fn func(key string, value interface{}) bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	cutoff := c.now().Add(-age)
+	var matches []*cacheEntry
+	for _, e := range c.values {
+		if e.lastAccess.Before(cutoff) && !c.expired(e) {
+			matches = append(matches, e)
+		}
+	}
+	for i, e := range matches {
+		c.pause(i)
+		if c.values[e.key] != e || c.expired(e) || !e.lastAccess.Before(cutoff) {
+			continue
+		}
+		if !fn(e.key, c.exposed(e)) {
+			return
+		}
+	}
+}
+
+// Demote moves the entry for key back to frequency 1 without changing
+// its value.  It returns false if the key is not present.
+func (c *Cache) Demote(key string) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	e, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	if e.freqNode.Value.(*listEntry).freq != 1 {
+		c.remEntry(e.freqNode, e)
+		c.place(e, 1)
+	}
+	// under LFUDA, start counting accesses again
+	e.hits = 0
+	return true
+}
+
+// GetTTL returns how long the entry for key has left to live, or
+// NoExpiration if it has no deadline.  It is negative for an entry that
+// has expired but not yet been removed.  Frequency is not affected.
+func (c *Cache) GetTTL(key string) (remaining time.Duration, ok bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	e, ok := c.values[key]
+	if !ok {
+		return 0, false
+	}
+	if e.expireAt.IsZero() {
+		return NoExpiration, true
+	}
+	return e.expireAt.Sub(c.now()), true
+}
+
+// AccessRate returns the average number of accesses per second to key
+// since it was inserted.  Entries younger than a second are treated as
+// one second old, so a new key does not report a huge rate.
+func (c *Cache) AccessRate(key string) (float64, bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	e, ok := c.lookup(key)
+	if !ok {
+		return 0, false
+	}
+	elapsed := c.now().Sub(e.createdAt).Seconds()
+	if elapsed < 1 {
+		elapsed = 1
+	}
+	return float64(e.freqNode.Value.(*listEntry).freq) / elapsed, true
+}
+
+// SetMeta attaches meta to the entry for key, without affecting its
+// frequency.  Metadata survives overwrites of the value.  It returns
+// false if the key is not present.
+func (c *Cache) SetMeta(key string, meta interface{}) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, ok := c.lookup(key); ok {
+		e.meta = meta
+		return true
+	}
+	return false
+}
+
+// GetMeta returns the metadata attached to the entry for key, without
+// affecting its frequency.
+func (c *Cache) GetMeta(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, ok := c.lookup(key); ok {
+		return e.meta, true
+	}
+	return nil, false
+}
+
+// IsDirty reports whether the value for key has changed since it was
+// last written back.  ok is false if the key is not present.
+func (c *Cache) IsDirty(key string) (dirty bool, ok bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, ok := c.lookup(key); ok {
+		return !e.persisted, true
+	}
+	return false, false
+}
+
+// DirtyColdEntries returns up to n entries that have not been
+// persisted, coldest first, i.e. the dirty entries closest to being
+// evicted.  It does not count as an access.
+func (c *Cache) DirtyColdEntries(n int) []Eviction {
+	c.lock.Lock()
+	defer c.unlock()
+	var dirty []Eviction
+	for place := c.freqs.Front(); place != nil && len(dirty) < n; place = place.Next() {
+		for e := place.Value.(*listEntry).entries.Front(); e != nil && len(dirty) < n; e = e.next {
+			if !e.persisted && !c.expired(e) {
+				dirty = append(dirty, Eviction{Key: e.key, Value: c.exposed(e)})
+			}
+		}
+	}
+	return dirty
+}
+
+// MarkPersisted marks the value for key as written back, so that it
+// is neither sent on WriteBackChannel nor EvictionChannel until it
+// changes again.  It returns false if the key is not present.
+func (c *Cache) MarkPersisted(key string) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, ok := c.lookup(key); ok {
+		c.setPersisted(e, true)
+		return true
+	}
+	return false
+}
+
+func (c *Cache) evict(count int, reason EvictionReason) int {
 	// No lock here so it can be called
 	// from within the lock (during Set)
-	var evicted int
-	for i := 0; i < count; {
+	victims, buckets, scanned := c.victims(count)
+	for _, victim := range victims {
+		c.evictEntry(victim, reason)
+	}
+	c.stats.LastEvictBuckets = buckets
+	c.stats.LastEvictScanned = scanned
+	return len(victims)
+}
+
+// victim returns the entry the next eviction would remove: the least
+// recently used entry of the lowest frequency in the lowest priority
+// class.
+func (c *Cache) victim() *cacheEntry {
+	if len(c.prios) == 0 && c.pinned == 0 && !c.TieBreakFIFO && !c.PreferCleanEviction {
 		if place := c.freqs.Front(); place != nil {
-			for entry := range place.Value.(*listEntry).entries {
-				if i < count {
-					if c.EvictionChannel != nil && !entry.persisted {
-						c.EvictionChannel <- Eviction{
-							Key:   entry.key,
-							Value: entry.value,
-						}
-					}
-					c.delete(entry)
-					evicted++
-					i++
-				}
-			}
+			return place.Value.(*listEntry).entries.Front()
 		}
+		return nil
 	}
-	return evicted
+	if victims, _, _ := c.victims(1); len(victims) > 0 {
+		return victims[0]
+	}
+	return nil
+}
+
+// evictEntry removes entry, accounting for it in stats and reporting
+// it on EvictionChannel if it was never persisted.  An expired entry
+// is removed as expired instead.
+func (c *Cache) evictEntry(entry *cacheEntry, reason EvictionReason) {
+	if c.expired(entry) {
+		c.expire(entry)
+		return
+	}
+	c.record(entry, reason)
+	if c.dynamicAging && reason == ReasonCapacity {
+		if freq := entry.freqNode.Value.(*listEntry).freq; freq > c.age {
+			c.age = freq
+		}
+	}
+	c.stats.Evictions++
+	c.stats.BytesEvicted += entry.cost
+	c.countEvictionRate()
+	if c.prefixes != nil {
+		c.countPrefix(entry.key, countEviction)
+	}
+	if !entry.persisted {
+		c.stats.DirtyEvictions++
+		ev := c.emitted(entry)
+		if c.OnDirtyEvict != nil {
+			c.call(func() { c.OnDirtyEvict(ev) })
+		}
+		if c.EvictionChannel != nil {
+			c.sendEviction(ev)
+		}
+		if c.EvictHandler != nil {
+			c.dispatch(ev)
+		}
+	}
+	c.delete(entry)
 }
 
+// emitted returns the Eviction reported for entry on a channel.
+func (c *Cache) emitted(entry *cacheEntry) Eviction {
+	value := c.exposed(entry)
+	return Eviction{Key: entry.key, Value: value, NilValue: value == nil}
+}
+
+// sendEviction sends ev on EvictionChannel, giving up after
+// EvictSendTimeout if it is set.
+func (c *Cache) sendEviction(ev Eviction) {
+	if c.SyncEvictAboveDirty > 0 && c.dirty <= c.SyncEvictAboveDirty {
+		select {
+		case c.EvictionChannel <- ev:
+		default:
+			c.stats.SkippedEvictions++
+		}
+		return
+	}
+	if c.EvictSendTimeout <= 0 {
+		c.EvictionChannel <- ev
+		return
+	}
+	select {
+	case c.EvictionChannel <- ev:
+		return
+	default:
+	}
+	timer := time.NewTimer(c.EvictSendTimeout)
+	defer timer.Stop()
+	select {
+	case c.EvictionChannel <- ev:
+	case <-timer.C:
+		if c.OnEvictTimeout != nil {
+			c.call(func() { c.OnEvictTimeout(ev) })
+		} else {
+			c.stats.DroppedEvictions++
+		}
+	}
+}
+
+// persist offers the count coldest entries on WriteBackChannel,
+// without blocking, marking those accepted as persisted.
 func (c *Cache) persist(count int) int {
-	var persisted int
-	for i := 0; i < count; {
-		if place := c.freqs.Front(); place != nil {
-			for entry := range place.Value.(*listEntry).entries {
-				if i < count {
-					if c.WriteBackChannel != nil && !entry.persisted {
-						select {
-						default:
-						case c.WriteBackChannel <- Eviction{Key: entry.key, Value: entry.value}:
-							entry.persisted = true
-							persisted++
-						}
-					}
-					i++
-				}
+	if c.WriteBackChannel == nil {
+		return 0
+	}
+	var persisted, examined int
+	full := false
+	for place := c.freqs.Front(); place != nil && examined < count; place = place.Next() {
+		for entry := place.Value.(*listEntry).entries.Front(); entry != nil && examined < count; entry = entry.next {
+			examined++
+			if entry.persisted {
+				continue
+			}
+			select {
+			default:
+				full = true
+			case c.WriteBackChannel <- c.emitted(entry):
+				c.setPersisted(entry, true)
+				persisted++
 			}
 		}
 	}
+	if full {
+		c.writeBackFull++
+	} else {
+		c.writeBackFull = 0
+	}
 	return persisted
 }
 
+// DisableBounds suspends automatic eviction by clearing UpperBound,
+// LowerBound and MaxBytes, e.g. for a bulk load.  EnableBounds
+// restores them.  Calling it again before EnableBounds does nothing.
+func (c *Cache) DisableBounds() {
+	c.lock.Lock()
+	defer c.unlock()
+	if c.saved != nil {
+		return
+	}
+	c.saved = &savedBounds{c.UpperBound, c.LowerBound, c.MaxBytes}
+	c.UpperBound, c.LowerBound, c.MaxBytes = 0, 0, 0
+}
+
+// EnableBounds restores the bounds cleared by DisableBounds and
+// immediately evicts down to them.
+func (c *Cache) EnableBounds() {
+	c.lock.Lock()
+	defer c.unlock()
+	if c.saved == nil {
+		return
+	}
+	c.UpperBound, c.LowerBound, c.MaxBytes = c.saved.upper, c.saved.lower, c.saved.maxBytes
+	c.saved = nil
+	c.enforceBounds()
+}
+
+type savedBounds struct {
+	upper, lower int
+	maxBytes     int64
+}
+
+// SetMemoryPressureFunc installs fn as a source of memory pressure,
+// from 0 (none) to 1 (critical).  While bounds are enabled, fn is
+// polled under the lock whenever bounds are checked, at least once
+// per Set, and UpperBound, LowerBound and MaxBytes are scaled down by
+// (1 - pressure), so the cache evicts earlier and deeper as pressure
+// rises.  fn should be cheap, e.g. return a value refreshed
+// periodically from runtime.MemStats.  A nil fn removes the hook.
+func (c *Cache) SetMemoryPressureFunc(fn func() float64) {
+	c.lock.Lock()
+	defer c.unlock()
+	c.pressure = fn
+}
+
+// relief returns 1 - pressure, the factor to scale bounds by.
+func (c *Cache) relief() float64 {
+	if c.pressure == nil {
+		return 1
+	}
+	p := c.pressure()
+	if p > 1 {
+		p = 1
+	}
+	if p < 0 {
+		p = 0
+	}
+	return 1 - p
+}
+
+// bounds returns the effective UpperBound and LowerBound, or zeros if
+// automatic eviction is disabled.
+func (c *Cache) bounds() (upper, lower int) {
+	if c.UpperBound <= 0 || c.LowerBound <= 0 {
+		return 0, 0
+	}
+	upper, lower = c.UpperBound, c.LowerBound
+	if lower > upper {
+		lower = upper
+	}
+	if r := c.relief(); r < 1 {
+		upper = int(float64(upper) * r)
+		lower = int(float64(lower) * r)
+		if upper < 1 {
+			upper = 1
+		}
+		if lower < 1 {
+			lower = 1
+		}
+	}
+	return upper, lower
+}
+
+// maxBytes returns the effective MaxBytes, or 0 if it is disabled.
+func (c *Cache) maxBytes() int64 {
+	if c.MaxBytes <= 0 {
+		return 0
+	}
+	max := c.MaxBytes
+	if r := c.relief(); r < 1 {
+		max = int64(float64(max) * r)
+		if max < 1 {
+			max = 1
+		}
+	}
+	return max
+}
+
+func (c *Cache) nextSeq() uint64 {
+	c.seq++
+	return c.seq
+}
+
+func (c *Cache) initialFrequency() int {
+	if c.InitialFrequency > 1 {
+		return c.InitialFrequency
+	}
+	return 1
+}
+
 func (c *Cache) increment(e *cacheEntry) {
+	now := c.now()
+	if c.CoalesceWindow > 0 && e.freqNode != nil && now.Sub(e.lastAccess) < c.CoalesceWindow {
+		return
+	}
+	e.lastAccess = now
+	if c.dynamicAging {
+		c.agedIncrement(e)
+		return
+	}
 	currentPlace := e.freqNode
 	var nextFreq int
 	var nextPlace *list.Element
 	if currentPlace == nil {
 		// new entry
-		nextFreq = 1
-		nextPlace = c.freqs.Front()
+		nextFreq = c.initialFrequency()
+		if place, ok := c.buckets[nextFreq]; ok {
+			nextPlace = place
+		} else {
+			nextPlace = c.freqs.Front()
+			for nextPlace != nil && nextPlace.Value.(*listEntry).freq < nextFreq {
+				nextPlace = nextPlace.Next()
+			}
+		}
 	} else {
 		// move up
-		nextFreq = currentPlace.Value.(*listEntry).freq + 1
+		cur := currentPlace.Value.(*listEntry)
+		nextFreq = cur.freq + 1
 		nextPlace = currentPlace.Next()
+		if cur.entries.Len() == 1 && (nextPlace == nil || nextPlace.Value.(*listEntry).freq != nextFreq) {
+			// sole entry with no bucket above it: bump the bucket
+			// in place instead of replacing it
+			delete(c.buckets, cur.freq)
+			cur.freq = nextFreq
+			c.buckets[nextFreq] = currentPlace
+			return
+		}
 	}
 
 	if nextPlace == nil || nextPlace.Value.(*listEntry).freq != nextFreq {
 		// create a new list entry
 		li := new(listEntry)
 		li.freq = nextFreq
-		li.entries = make(map[*cacheEntry]byte)
 		if currentPlace != nil {
 			nextPlace = c.freqs.InsertAfter(li, currentPlace)
+		} else if nextPlace != nil {
+			nextPlace = c.freqs.InsertBefore(li, nextPlace)
 		} else {
-			nextPlace = c.freqs.PushFront(li)
+			nextPlace = c.freqs.PushBack(li)
 		}
+		c.buckets[nextFreq] = nextPlace
 	}
-	e.freqNode = nextPlace
-	nextPlace.Value.(*listEntry).entries[e] = 1
 	if currentPlace != nil {
 		// remove from current position
 		c.remEntry(currentPlace, e)
 	}
+	e.freqNode = nextPlace
+	nextPlace.Value.(*listEntry).entries.PushBack(e)
+	if c.MaxBuckets > 0 && c.freqs.Len() > c.MaxBuckets {
+		c.coalesce(nextPlace)
+	}
+}
+
+// coalesce merges adjacent buckets until at most MaxBuckets remain.
+// Each step merges the pair with the closest frequencies into the
+// colder one, skipping the pair that would lower keep, the bucket an
+// access just moved an entry to; if that is the only pair, the colder
+// bucket is raised into keep instead.  Merged entries keep their
+// relative order, colder bucket first.
+func (c *Cache) coalesce(keep *list.Element) {
+	for c.freqs.Len() > c.MaxBuckets && c.freqs.Len() > 1 {
+		var lo *list.Element
+		var best float64
+		for place := c.freqs.Front(); place.Next() != nil; place = place.Next() {
+			if place.Next() == keep {
+				continue
+			}
+			ratio := float64(place.Next().Value.(*listEntry).freq) / float64(place.Value.(*listEntry).freq)
+			if lo == nil || ratio < best {
+				lo, best = place, ratio
+			}
+		}
+		freq := 0
+		if lo == nil {
+			lo = keep.Prev()
+			freq = keep.Value.(*listEntry).freq
+		}
+		c.mergeBuckets(lo, freq)
+	}
+}
+
+// mergeBuckets moves the entries of the bucket after lo to the back of
+// lo and removes it.  If freq > 0, lo takes that frequency.
+func (c *Cache) mergeBuckets(lo *list.Element, freq int) {
+	hi := lo.Next()
+	from, to := hi.Value.(*listEntry), lo.Value.(*listEntry)
+	for e := from.entries.Front(); e != nil; {
+		moved := e
+		e = e.next
+		from.entries.Remove(moved)
+		to.entries.PushBack(moved)
+		moved.freqNode = lo
+	}
+	delete(c.buckets, from.freq)
+	c.freqs.Remove(hi)
+	if freq > 0 {
+		delete(c.buckets, to.freq)
+		to.freq = freq
+		c.buckets[freq] = lo
+	}
+}
+
+// place puts a detached entry into the bucket for freq, creating the
+// bucket if needed.
+func (c *Cache) place(e *cacheEntry, freq int) {
+	if freq < 1 {
+		freq = 1
+	}
+	at, ok := c.buckets[freq]
+	if !ok {
+		at = c.freqs.Back()
+		for at != nil && at.Value.(*listEntry).freq > freq {
+			at = at.Prev()
+		}
+		li := new(listEntry)
+		li.freq = freq
+		if at == nil {
+			at = c.freqs.PushFront(li)
+		} else {
+			at = c.freqs.InsertAfter(li, at)
+		}
+		c.buckets[freq] = at
+	}
+	e.freqNode = at
+	at.Value.(*listEntry).entries.PushBack(e)
 }
 
 func (c *Cache) remEntry(place *list.Element, entry *cacheEntry) {
-	entries := place.Value.(*listEntry).entries
-	delete(entries, entry)
-	if len(entries) == 0 {
+	li := place.Value.(*listEntry)
+	li.entries.Remove(entry)
+	if li.entries.Len() == 0 {
+		delete(c.buckets, li.freq)
 		c.freqs.Remove(place)
 	}
 }
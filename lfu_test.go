@@ -1,8 +1,14 @@
 package lfu
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestLFU(t *testing.T) {
@@ -79,3 +85,1649 @@ func TestEvictionOrder(t *testing.T) {
 		t.Error("Incorrect eviction order")
 	}
 }
+
+func TestTotalFrequency(t *testing.T) {
+	c := New()
+	if f := c.TotalFrequency(); f != 0 {
+		t.Errorf("Empty cache has nonzero total frequency: %v", f)
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Get("a")
+	if f := c.TotalFrequency(); f != 4 {
+		t.Errorf("Total frequency is wrong: %v != 4", f)
+	}
+}
+
+func TestGetOrCreate(t *testing.T) {
+	var created int
+	create := func(key string) interface{} {
+		created++
+		return key + key
+	}
+
+	c := New()
+	if v := c.GetOrCreate("a", create); v != "aa" {
+		t.Errorf("Wrong value created: %v", v)
+	}
+	if v := c.GetOrCreate("a", create); v != "aa" || created != 1 {
+		t.Errorf("Existing value was recreated: %v, %v", v, created)
+	}
+	if f, _ := c.FrequencyOf("a"); f != 2 {
+		t.Errorf("Hit did not bump frequency: %v != 2", f)
+	}
+}
+
+func TestMinMaxFrequency(t *testing.T) {
+	c := New()
+	if _, ok := c.MaxFrequency(); ok {
+		t.Errorf("Empty cache reported a max frequency")
+	}
+	if _, ok := c.MinFrequency(); ok {
+		t.Errorf("Empty cache reported a min frequency")
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Get("a")
+	if f, _ := c.MaxFrequency(); f != 3 {
+		t.Errorf("Max frequency is wrong: %v != 3", f)
+	}
+	if f, _ := c.MinFrequency(); f != 1 {
+		t.Errorf("Min frequency is wrong: %v != 1", f)
+	}
+}
+
+func TestGetWithFreq(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	if v, f, ok := c.GetWithFreq("a"); !ok || v != 1 || f != 2 {
+		t.Errorf("Wrong result: %v, %v, %v", v, f, ok)
+	}
+	if f, ok := c.FrequencyOf("a"); !ok || f != 2 {
+		t.Errorf("FrequencyOf counted an access: %v, %v", f, ok)
+	}
+	if _, _, ok := c.GetWithFreq("b"); ok {
+		t.Errorf("Missing key was found")
+	}
+	c.Set("b", 2)
+	freqs := c.FrequenciesOf([]string{"a", "b", "missing"})
+	if len(freqs) != 2 || freqs["a"] != 2 || freqs["b"] != 1 {
+		t.Errorf("Wrong frequencies: %v", freqs)
+	}
+}
+
+func TestStructuralOverheadBytes(t *testing.T) {
+	c := New()
+	if n := c.StructuralOverheadBytes(); n != 0 {
+		t.Errorf("Empty cache has overhead: %v", n)
+	}
+	c.Set("a", 1)
+	one := c.StructuralOverheadBytes()
+	c.Set("b", 2)
+	two := c.StructuralOverheadBytes()
+	c.Get("b")
+	if three := c.StructuralOverheadBytes(); one <= 0 || two <= one || three <= two {
+		t.Errorf("Overhead does not grow: %v, %v, %v", one, two, three)
+	}
+}
+
+func TestLoadOrStore(t *testing.T) {
+	c := New()
+	if v, loaded := c.LoadOrStore("a", "a"); loaded || v != "a" {
+		t.Errorf("Value was not stored: %v, %v", v, loaded)
+	}
+	if v, loaded := c.LoadOrStore("a", "b"); !loaded || v != "a" {
+		t.Errorf("Existing value was not loaded: %v, %v", v, loaded)
+	}
+	if f := c.TotalFrequency(); f != 2 {
+		t.Errorf("Load did not bump frequency: %v != 2", f)
+	}
+}
+
+func TestHardCap(t *testing.T) {
+	c := New()
+	c.HardCap = 2
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if c.TrySet("c", 3) {
+		t.Error("New key was admitted above HardCap")
+	}
+	if !c.TrySet("a", 4) {
+		t.Error("Overwrite was rejected at HardCap")
+	}
+	if l := c.Len(); l != 2 {
+		t.Errorf("Length is wrong: %v != 2", l)
+	}
+
+	c.HardCapEvict = true
+	if !c.TrySet("c", 3) {
+		t.Error("New key was rejected with HardCapEvict")
+	}
+	if v := c.Get("b"); v != nil {
+		t.Errorf("Coldest entry was not evicted: %v", v)
+	}
+	if l := c.Len(); l != 2 {
+		t.Errorf("Length is wrong: %v != 2", l)
+	}
+}
+
+func TestGetBatchRanked(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("c")
+	c.Get("c")
+	c.Get("b")
+
+	ranked := c.GetBatchRanked([]string{"a", "b", "c", "missing"})
+	if len(ranked) != 3 {
+		t.Fatalf("Wrong number of entries: %v != 3", len(ranked))
+	}
+	for i, key := range []string{"c", "b", "a"} {
+		if ranked[i].Key != key {
+			t.Errorf("Wrong order at %v: %v != %v", i, ranked[i].Key, key)
+		}
+	}
+}
+
+func TestIsDirty(t *testing.T) {
+	ch := make(chan Eviction, 1)
+
+	c := New()
+	c.WriteBackChannel = ch
+	c.Set("a", 1)
+	if dirty, ok := c.IsDirty("a"); !ok || !dirty {
+		t.Errorf("New entry is not dirty: %v, %v", dirty, ok)
+	}
+	c.WriteBack(1)
+	if dirty, ok := c.IsDirty("a"); !ok || dirty {
+		t.Errorf("Persisted entry is dirty: %v, %v", dirty, ok)
+	}
+	if _, ok := c.IsDirty("b"); ok {
+		t.Error("Missing key was reported present")
+	}
+}
+
+func TestMarkPersisted(t *testing.T) {
+	ch := make(chan Eviction, 1)
+
+	c := New()
+	c.EvictionChannel = ch
+	c.Set("a", 1)
+	if !c.MarkPersisted("a") {
+		t.Error("Present key was reported missing")
+	}
+	if c.MarkPersisted("b") {
+		t.Error("Missing key was reported present")
+	}
+	c.Evict(1)
+	if len(ch) != 0 {
+		t.Error("Persisted entry was sent on eviction")
+	}
+}
+
+func TestDirtyEvictions(t *testing.T) {
+	var dirty []string
+
+	c := New()
+	c.OnDirtyEvict = func(ev Eviction) {
+		dirty = append(dirty, ev.Key)
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.MarkPersisted("a")
+	if n := c.Evict(3); n != 2 {
+		t.Errorf("Number of evicted items is wrong: %v != 2", n)
+	}
+
+	stats := c.Stats()
+	if stats.Evictions != 2 || stats.DirtyEvictions != 1 {
+		t.Errorf("Stats are wrong: %+v", stats)
+	}
+	if len(dirty) != 1 || dirty[0] != "b" {
+		t.Errorf("OnDirtyEvict was not called for the dirty entry: %v", dirty)
+	}
+}
+
+func TestEvictBelowMean(t *testing.T) {
+	c := New()
+	if n := c.EvictBelowMean(); n != 0 {
+		t.Errorf("Evicted from an empty cache: %v", n)
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("c")
+	c.Get("c")
+	c.Get("c")
+
+	// mean is (1 + 1 + 4) / 3 = 2
+	if n := c.EvictBelowMean(); n != 2 {
+		t.Errorf("Number of evicted items is wrong: %v != 2", n)
+	}
+	if v := c.Get("c"); v != 3 {
+		t.Errorf("Hot entry was evicted: %v", v)
+	}
+}
+
+func TestSetWithDeadline(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	c := New()
+	c.now = func() time.Time { return now }
+	c.SetWithDeadline("a", "a", now.Add(time.Second))
+	if v := c.Get("a"); v != "a" {
+		t.Errorf("Value was not saved: %v != 'a'", v)
+	}
+
+	now = now.Add(time.Second)
+	if v := c.Get("a"); v != nil {
+		t.Errorf("Value did not expire at its deadline: %v", v)
+	}
+	if l := c.Len(); l != 0 {
+		t.Errorf("Expired entry was not removed: %v != 0", l)
+	}
+}
+
+func TestRangeOlderThan(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	c := New()
+	c.now = func() time.Time { return now }
+	c.Set("a", 1)
+	c.Set("b", 2)
+	now = now.Add(time.Minute)
+	c.Get("b")
+
+	var keys []string
+	c.RangeOlderThan(30*time.Second, func(key string, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("Wrong entries visited: %v", keys)
+	}
+}
+
+func TestUnlocked(t *testing.T) {
+	c := NewUnlocked()
+	c.Set("a", "a")
+	if v := c.Get("a"); v != "a" {
+		t.Errorf("Value was not saved: %v != 'a'", v)
+	}
+	if n := c.Evict(1); n != 1 {
+		t.Errorf("Number of evicted items is wrong: %v != 1", n)
+	}
+}
+
+func TestBucketCount(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if n := c.BucketCount(); n != 1 {
+		t.Errorf("Bucket count is wrong: %v != 1", n)
+	}
+	c.Get("a")
+	if n := c.BucketCount(); n != 2 {
+		t.Errorf("Bucket count is wrong: %v != 2", n)
+	}
+}
+
+func TestSetAtomic(t *testing.T) {
+	c := New()
+	c.HardCap = 3
+	c.Set("a", 1)
+	if c.SetAtomic(map[string]interface{}{"a": 2, "b": 2, "c": 3, "d": 4}) {
+		t.Error("Batch exceeding HardCap was committed")
+	}
+	if l := c.Len(); l != 1 {
+		t.Errorf("Partial batch was inserted: %v != 1", l)
+	}
+	if !c.SetAtomic(map[string]interface{}{"a": 2, "b": 2, "c": 3}) {
+		t.Error("Batch within HardCap was not committed")
+	}
+	if v := c.Get("a"); v != 2 {
+		t.Errorf("Existing key was not overwritten: %v != 2", v)
+	}
+	if l := c.Len(); l != 3 {
+		t.Errorf("Length is wrong: %v != 3", l)
+	}
+
+	c = New()
+	c.Sizer = func(key string, value interface{}) int64 { return 4 }
+	c.MaxBytes = 8
+	if c.SetAtomic(map[string]interface{}{"a": 1, "b": 2, "c": 3}) {
+		t.Error("Batch exceeding MaxBytes was committed")
+	}
+	if l := c.Len(); l != 0 {
+		t.Errorf("Partial batch was inserted: %v != 0", l)
+	}
+	c.Set("a", 1)
+	if !c.SetAtomic(map[string]interface{}{"a": 2, "b": 2}) {
+		t.Error("Batch within MaxBytes was not committed")
+	}
+}
+
+func TestDemote(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Get("a")
+	c.Set("c", 3)
+	c.Get("c")
+	if !c.Demote("a") {
+		t.Error("Present key was reported missing")
+	}
+	if c.Demote("missing") {
+		t.Error("Missing key was reported present")
+	}
+	if f := c.TotalFrequency(); f != 4 {
+		t.Errorf("Entry was not demoted to frequency 1: %v != 4", f)
+	}
+	c.Evict(2)
+	if v := c.Get("c"); v != 3 {
+		t.Errorf("Demoted entry outlived a hotter one: %v", v)
+	}
+}
+
+func TestEvictionPreview(t *testing.T) {
+	ch := make(chan Eviction, 10)
+
+	c := New()
+	c.EvictionChannel = ch
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	c.Get("0")
+	c.Get("5")
+	c.Get("5")
+
+	preview := c.EvictionPreview(9)
+	if l := c.Len(); l != 10 {
+		t.Errorf("Preview modified the cache: %v != 10", l)
+	}
+	if n := c.Evict(9); n != len(preview) {
+		t.Fatalf("Preview size is wrong: %v != %v", len(preview), n)
+	}
+	for i, p := range preview {
+		if ev := <-ch; ev.Key != p.Key {
+			t.Errorf("Preview order differs at %v: %v != %v", i, p.Key, ev.Key)
+		}
+	}
+	if v := c.Get("5"); v != 5 {
+		t.Errorf("Hottest entry was evicted: %v", v)
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	store := map[string]interface{}{"a": "a"}
+	fail := errors.New("write failed")
+
+	c := New()
+	c.WriteThrough.Load = func(key string) (interface{}, bool) {
+		v, ok := store[key]
+		return v, ok
+	}
+	c.WriteThrough.Write = func(key string, value interface{}) error {
+		if key == "bad" {
+			return fail
+		}
+		store[key] = value
+		return nil
+	}
+
+	if v := c.Get("a"); v != "a" {
+		t.Errorf("Value was not loaded: %v != 'a'", v)
+	}
+	if l := c.Len(); l != 1 {
+		t.Errorf("Loaded value was not cached: %v != 1", l)
+	}
+	if v := c.Get("missing"); v != nil {
+		t.Errorf("Missing value was loaded: %v", v)
+	}
+
+	if err := c.Put("b", "b"); err != nil {
+		t.Error(err)
+	}
+	if store["b"] != "b" {
+		t.Error("Value was not written through")
+	}
+	if dirty, _ := c.IsDirty("b"); dirty {
+		t.Error("Written value is dirty")
+	}
+
+	if err := c.Put("bad", "bad"); err != fail {
+		t.Errorf("Write error was not returned: %v", err)
+	}
+	if _, ok := c.IsDirty("bad"); ok {
+		t.Error("Failed write was cached")
+	}
+}
+
+func TestWriteThroughLoadUnlocked(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+
+	c := New()
+	c.WriteThrough.Load = func(key string) (interface{}, bool) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "loaded", true
+	}
+	c.Set("a", 1)
+	results := make(chan interface{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() { results <- c.Get("slow") }()
+	}
+	for atomic.LoadInt32(&calls) == 0 {
+		runtime.Gosched()
+	}
+	hit := make(chan interface{})
+	go func() { hit <- c.Get("a") }()
+	select {
+	case v := <-hit:
+		if v != 1 {
+			t.Errorf("Wrong value: %v != 1", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Hit waited for a load of another key")
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if v := <-results; v != "loaded" {
+			t.Errorf("Wrong loaded value: %v", v)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Concurrent misses loaded %v times", n)
+	}
+
+	c.WriteThrough.Load = func(key string) (interface{}, bool) {
+		c.Set(key, "written")
+		return "stale", true
+	}
+	if v := c.Get("w"); v != "written" || c.Get("w") != "written" {
+		t.Errorf("Load overwrote a concurrent write: %v", v)
+	}
+}
+
+func TestGetTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	c := New()
+	c.now = func() time.Time { return now }
+	c.Set("a", 1)
+	c.SetWithDeadline("b", 2, now.Add(time.Minute))
+
+	if ttl, ok := c.GetTTL("a"); !ok || ttl != NoExpiration {
+		t.Errorf("Entry without deadline has a TTL: %v, %v", ttl, ok)
+	}
+	if ttl, ok := c.GetTTL("b"); !ok || ttl != time.Minute {
+		t.Errorf("TTL is wrong: %v, %v", ttl, ok)
+	}
+	now = now.Add(2 * time.Minute)
+	if ttl, ok := c.GetTTL("b"); !ok || ttl != -time.Minute {
+		t.Errorf("Expired TTL is wrong: %v, %v", ttl, ok)
+	}
+	if _, ok := c.GetTTL("missing"); ok {
+		t.Error("Missing key was reported present")
+	}
+	if f := c.TotalFrequency(); f != 2 {
+		t.Errorf("GetTTL bumped frequency: %v != 2", f)
+	}
+}
+
+func TestInitialFrequency(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.InitialFrequency = 3
+	c.Set("c", 3)
+	if f := c.TotalFrequency(); f != 6 {
+		t.Errorf("New entry did not start at InitialFrequency: %v != 6", f)
+	}
+	c.Evict(2)
+	if v := c.Get("c"); v != 3 {
+		t.Errorf("New entry was evicted before colder ones: %v", v)
+	}
+}
+
+func TestEvictScanStats(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("c")
+	c.Evict(3)
+
+	stats := c.Stats()
+	if stats.LastEvictBuckets != 2 || stats.LastEvictScanned != 3 {
+		t.Errorf("Scan stats are wrong: %+v", stats)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("b")
+
+	old := c.Swap(map[string]interface{}{"c": 3})
+	if len(old) != 2 || old[0].Key != "a" || old[1].Key != "b" {
+		t.Errorf("Old contents are wrong: %v", old)
+	}
+	if l := c.Len(); l != 1 {
+		t.Errorf("Length is wrong: %v != 1", l)
+	}
+	if v := c.Get("a"); v != nil {
+		t.Errorf("Old value survived the swap: %v", v)
+	}
+	if v := c.Get("c"); v != 3 {
+		t.Errorf("New value was not stored: %v != 3", v)
+	}
+}
+
+func TestDetectMutation(t *testing.T) {
+	var mutated []string
+
+	c := New()
+	c.DetectMutation = true
+	c.OnMutation = func(key string) {
+		mutated = append(mutated, key)
+	}
+	b := []byte("abc")
+	m := map[string]int{"x": 1}
+	c.Set("b", b)
+	c.Set("m", m)
+	c.Set("s", "abc")
+
+	c.Get("b")
+	c.Get("m")
+	c.Get("s")
+	if len(mutated) != 0 {
+		t.Errorf("Unmodified values were reported: %v", mutated)
+	}
+
+	b[0] = 'x'
+	m["y"] = 2
+	c.Get("b")
+	c.Get("m")
+	if len(mutated) != 2 || mutated[0] != "b" || mutated[1] != "m" {
+		t.Errorf("Mutations were not reported: %v", mutated)
+	}
+}
+
+func TestMemoryPressure(t *testing.T) {
+	pressure := 0.0
+
+	c := New()
+	c.UpperBound = 10
+	c.LowerBound = 8
+	c.SetMemoryPressureFunc(func() float64 { return pressure })
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if l := c.Len(); l != 10 {
+		t.Errorf("Evicted without pressure: %v != 10", l)
+	}
+
+	pressure = 0.5
+	c.Set("x", 0)
+	if l := c.Len(); l != 4 {
+		t.Errorf("Pressure did not scale eviction: %v != 4", l)
+	}
+
+	pressure = 0
+	c = New()
+	c.Sizer = func(key string, value interface{}) int64 { return 1 }
+	c.MaxBytes = 100
+	c.SetMemoryPressureFunc(func() float64 { return pressure })
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	pressure = 0.5
+	c.Set("x", 0)
+	if l := c.Len(); l != 50 {
+		t.Errorf("Pressure did not scale MaxBytes: %v != 50", l)
+	}
+}
+
+func TestContextPropagation(t *testing.T) {
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "span")
+	var seen []interface{}
+
+	c := New()
+	c.WriteThrough.LoadCtx = func(ctx context.Context, key string) (interface{}, bool) {
+		seen = append(seen, ctx.Value(ctxKey{}))
+		return "loaded", true
+	}
+	c.WriteThrough.WriteCtx = func(ctx context.Context, key string, value interface{}) error {
+		seen = append(seen, ctx.Value(ctxKey{}))
+		return nil
+	}
+
+	if v := c.GetCtx(ctx, "a"); v != "loaded" {
+		t.Errorf("Value was not loaded: %v", v)
+	}
+	c.SetCtx(ctx, "b", "b")
+	c.Set("c", "c")
+	if len(seen) != 3 || seen[0] != "span" || seen[1] != "span" || seen[2] != nil {
+		t.Errorf("Context was not propagated: %v", seen)
+	}
+}
+
+func BenchmarkUniformGet(b *testing.B) {
+	c := New()
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%v", i)
+		c.Set(keys[i], i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkHotKeyGet(b *testing.B) {
+	c := New()
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get("0")
+	}
+}
+
+func TestCurrentCost(t *testing.T) {
+	c := New()
+	c.Sizer = func(key string, value interface{}) int64 {
+		return int64(len(value.(string)))
+	}
+	c.MaxBytes = 10
+	c.Set("a", "aaaa")
+	c.Set("b", "bbbb")
+	if n := c.CurrentCost(); n != 8 {
+		t.Errorf("Cost is wrong: %v != 8", n)
+	}
+	c.Set("a", "aa")
+	if n := c.CurrentBytes(); n != 6 {
+		t.Errorf("Cost was not updated on overwrite: %v != 6", n)
+	}
+	c.Set("c", "cccccc")
+	if n := c.CurrentCost(); n != 8 || c.Len() != 2 {
+		t.Errorf("MaxBytes was not enforced: %v, %v", n, c.Len())
+	}
+	if v := c.Get("b"); v != nil {
+		t.Errorf("Coldest entry was not evicted: %v", v)
+	}
+	c.Delete("a")
+	if n := c.CurrentCost(); n != 6 {
+		t.Errorf("Cost was not updated on delete: %v != 6", n)
+	}
+}
+
+func TestEvictBytes(t *testing.T) {
+	c := New()
+	c.Sizer = func(key string, value interface{}) int64 {
+		return int64(len(value.(string)))
+	}
+	c.Set("a", "a")
+	c.Set("b", "bbbbbb")
+	c.Set("c", "cc")
+	c.Set("d", "dddddddd")
+	c.Get("d")
+	if n := c.EvictBytes(5); n != 6 {
+		t.Errorf("Wrong number of bytes freed: %v != 6", n)
+	}
+	if c.Get("b") != nil || c.Len() != 3 {
+		t.Errorf("Largest cold entry was not evicted alone")
+	}
+	if n := c.EvictBytes(10); n != 11 || c.Len() != 0 {
+		t.Errorf("Eviction did not move on to hotter buckets: %v, %v", n, c.Len())
+	}
+	if n := c.TotalBytesEvicted(); n != 17 {
+		t.Errorf("Evicted bytes are wrong: %v != 17", n)
+	}
+}
+
+func TestUtilization(t *testing.T) {
+	c := New()
+	c.Sizer = func(key string, value interface{}) int64 {
+		return int64(len(value.(string)))
+	}
+	c.Set("a", "aaaa")
+	if u := c.Utilization(); u != 0 {
+		t.Errorf("Utilization without bounds: %v != 0", u)
+	}
+	c.UpperBound = 4
+	c.LowerBound = 2
+	if u := c.Utilization(); u != 0.25 {
+		t.Errorf("Count utilization is wrong: %v != 0.25", u)
+	}
+	c.MaxBytes = 8
+	if u := c.Utilization(); u != 0.5 {
+		t.Errorf("Cost utilization is wrong: %v != 0.5", u)
+	}
+	c.UpperBound = 0
+	c.Set("b", "b")
+	if u := c.Utilization(); u != 0.625 {
+		t.Errorf("Cost utilization is wrong: %v != 0.625", u)
+	}
+}
+
+func TestEvictMFU(t *testing.T) {
+	ch := make(chan Eviction, 2)
+
+	c := New()
+	c.EvictionChannel = ch
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("b")
+	c.Get("c")
+	c.Get("c")
+	if n := c.EvictMFU(2); n != 2 {
+		t.Errorf("Number of evicted items is wrong: %v != 2", n)
+	}
+	if ev := <-ch; ev.Key != "c" {
+		t.Errorf("Hottest entry was not evicted first: %v", ev.Key)
+	}
+	if ev := <-ch; ev.Key != "b" {
+		t.Errorf("Wrong entry evicted: %v", ev.Key)
+	}
+	if v := c.Get("a"); v != 1 {
+		t.Errorf("Coldest entry was evicted: %v", v)
+	}
+}
+
+func TestNextVictim(t *testing.T) {
+	c := New()
+	if _, ok := c.NextVictim(); ok {
+		t.Error("Empty cache has a victim")
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	if ev, ok := c.NextVictim(); !ok || ev.Key != "b" {
+		t.Errorf("Wrong victim: %v, %v", ev, ok)
+	}
+	c.Evict(1)
+	if v := c.Get("b"); v != nil {
+		t.Errorf("Evict did not remove the predicted victim: %v", v)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	c := New()
+	c.Set("n", 1)
+	add := func(old interface{}) interface{} {
+		return old.(int) + 1
+	}
+	if !c.Update("n", add) {
+		t.Error("Present key was reported missing")
+	}
+	if c.Update("missing", add) {
+		t.Error("Missing key was reported present")
+	}
+	if v := c.Get("n"); v != 2 {
+		t.Errorf("Value was not updated: %v != 2", v)
+	}
+	if f := c.TotalFrequency(); f != 3 {
+		t.Errorf("Update did not bump frequency: %v != 3", f)
+	}
+}
+
+func TestGetOrError(t *testing.T) {
+	c := New()
+	c.Set("a", nil)
+	if v, err := c.GetOrError("a"); err != nil || v != nil {
+		t.Errorf("Stored nil was reported missing: %v, %v", v, err)
+	}
+	if _, err := c.GetOrError("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Miss did not return ErrNotFound: %v", err)
+	}
+}
+
+func TestEvictSendTimeout(t *testing.T) {
+	ch := make(chan Eviction)
+	var fallback []string
+
+	c := New()
+	c.EvictionChannel = ch
+	c.EvictSendTimeout = time.Millisecond
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Evict(1)
+	if n := c.Stats().DroppedEvictions; n != 1 {
+		t.Errorf("Timed out eviction was not counted: %v != 1", n)
+	}
+
+	c.OnEvictTimeout = func(ev Eviction) {
+		fallback = append(fallback, ev.Key)
+	}
+	c.Evict(1)
+	if len(fallback) != 1 || fallback[0] != "b" {
+		t.Errorf("Timed out eviction was not handed to the fallback: %v", fallback)
+	}
+}
+
+func TestAccessRate(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	c := New()
+	c.now = func() time.Time { return now }
+	c.Set("a", 1)
+	c.Get("a")
+	if r, ok := c.AccessRate("a"); !ok || r != 2 {
+		t.Errorf("Rate of a new entry is wrong: %v, %v", r, ok)
+	}
+	now = now.Add(4 * time.Second)
+	c.Get("a")
+	c.Get("a")
+	if r, ok := c.AccessRate("a"); !ok || r != 1 {
+		t.Errorf("Rate is wrong: %v, %v", r, ok)
+	}
+	if _, ok := c.AccessRate("missing"); ok {
+		t.Error("Missing key was reported present")
+	}
+}
+
+func TestExpirationChannel(t *testing.T) {
+	now := time.Unix(1000, 0)
+	evictions := make(chan Eviction, 1)
+	expirations := make(chan Eviction, 1)
+
+	c := New()
+	c.now = func() time.Time { return now }
+	c.EvictionChannel = evictions
+	c.ExpirationChannel = expirations
+	c.SetWithDeadline("a", 1, now)
+	c.Get("a")
+	if len(evictions) != 0 {
+		t.Error("Expired entry was sent on EvictionChannel")
+	}
+	if ev := <-expirations; ev.Key != "a" || ev.Value != 1 {
+		t.Errorf("Wrong expiration: %v", ev)
+	}
+
+	c.Set("b", 2)
+	c.Evict(1)
+	if len(expirations) != 0 {
+		t.Error("Evicted entry was sent on ExpirationChannel")
+	}
+	<-evictions
+
+	c.SetWithDeadline("c", 3, now)
+	if n := c.Evict(1); n != 1 || c.Len() != 0 {
+		t.Errorf("Expired entry was not removed: %v, %v", n, c.Len())
+	}
+	if len(evictions) != 0 || len(expirations) != 1 {
+		t.Errorf("Expired entry was evicted: %v, %v", len(evictions), len(expirations))
+	}
+}
+
+func TestKeysAtFrequency(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("b")
+
+	if keys := c.KeysAtFrequency(1); len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Errorf("Wrong keys at frequency 1: %v", keys)
+	}
+	if keys := c.KeysAtFrequency(2); len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("Wrong keys at frequency 2: %v", keys)
+	}
+	if keys := c.KeysAtFrequency(3); keys == nil || len(keys) != 0 {
+		t.Errorf("Wrong keys at frequency 3: %v", keys)
+	}
+	if f := c.TotalFrequency(); f != 4 {
+		t.Errorf("KeysAtFrequency bumped frequency: %v != 4", f)
+	}
+}
+
+func TestBucketIndex(t *testing.T) {
+	c := New()
+	c.UpperBound = 50
+	c.LowerBound = 40
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("%v", (i*7919)%97)
+		switch i % 5 {
+		case 0:
+			c.Set(key, i)
+		case 1:
+			c.Demote(key)
+		case 2:
+			c.Delete(key)
+		default:
+			c.Get(key)
+		}
+	}
+	if len(c.buckets) != c.freqs.Len() {
+		t.Fatalf("Index size is wrong: %v != %v", len(c.buckets), c.freqs.Len())
+	}
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		if freq := place.Value.(*listEntry).freq; c.buckets[freq] != place {
+			t.Errorf("Bucket for frequency %v is not indexed", freq)
+		}
+	}
+}
+
+func TestMaxBuckets(t *testing.T) {
+	c := New()
+	c.MaxBuckets = 4
+	for i := 0; i < 8; i++ {
+		key := fmt.Sprintf("%v", i)
+		c.Set(key, i)
+		for j := 0; j < i; j++ {
+			c.Get(key)
+		}
+	}
+	if n := c.BucketCount(); n > 4 {
+		t.Errorf("Bucket count exceeds MaxBuckets: %v", n)
+	}
+	if err := c.checkInvariants(); err != nil {
+		t.Error(err)
+	}
+	var prev int
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		li := place.Value.(*listEntry)
+		if li.freq <= prev {
+			t.Errorf("Frequencies are not increasing: %v after %v", li.freq, prev)
+		}
+		prev = li.freq
+		for e := li.entries.Front(); e != nil; e = e.next {
+			if e.freqNode != place {
+				t.Errorf("Entry %v points at the wrong bucket", e.key)
+			}
+		}
+	}
+	c.Evict(1)
+	if v := c.Get("0"); v != nil {
+		t.Errorf("Coldest entry survived: %v", v)
+	}
+}
+
+func TestMaxBucketsPromotion(t *testing.T) {
+	for _, max := range []int{1, 2, 3} {
+		c := New()
+		c.MaxBuckets = max
+		c.Set("a", 1)
+		c.Set("b", 2)
+		c.Set("c", 3)
+		c.Set("d", 4)
+		c.Get("a")
+		c.Get("b")
+		for i := 0; i < 3; i++ {
+			c.Get("c")
+		}
+		for i := 0; i < 1000; i++ {
+			c.Get("a")
+		}
+		if err := c.checkInvariants(); err != nil {
+			t.Error(err)
+		}
+		if n := c.BucketCount(); n > max {
+			t.Errorf("Bucket count exceeds %v: %v", max, n)
+		}
+		fa, _ := c.FrequencyOf("a")
+		for _, key := range []string{"b", "c", "d"} {
+			if f, _ := c.FrequencyOf(key); f >= fa && max > 1 {
+				t.Errorf("MaxBuckets %v: %v at %v did not fall behind a at %v", max, key, f, fa)
+			}
+		}
+		if fa < 1000 {
+			t.Errorf("MaxBuckets %v: a stopped rising at %v", max, fa)
+		}
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	c := New()
+	if n := c.WriteBack(1); n != 0 {
+		t.Errorf("Empty cache wrote back entries: %v", n)
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("c")
+	if _, err := c.WriteBackChecked(1); err != ErrNoWriteBackChannel {
+		t.Errorf("Missing channel was not reported: %v", err)
+	}
+
+	ch := make(chan Eviction, 10)
+	c.WriteBackChannel = ch
+	c.MarkPersisted("a")
+	if n, err := c.WriteBackChecked(10); err != nil || n != 2 {
+		t.Errorf("Number of written back entries is wrong: %v, %v", n, err)
+	}
+	if ev := <-ch; ev.Key != "b" {
+		t.Errorf("Coldest dirty entry was not written first: %v", ev.Key)
+	}
+	if ev := <-ch; ev.Key != "c" {
+		t.Errorf("Wrong entry written back: %v", ev.Key)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	c := New()
+	if c.SetMeta("a", "shard-1") {
+		t.Error("Metadata was attached to a missing key")
+	}
+	c.Set("a", 1)
+	if !c.SetMeta("a", "shard-1") {
+		t.Error("Present key was reported missing")
+	}
+	c.Set("a", 2)
+	if m, ok := c.GetMeta("a"); !ok || m != "shard-1" {
+		t.Errorf("Metadata did not survive an overwrite: %v, %v", m, ok)
+	}
+	if f := c.TotalFrequency(); f != 2 {
+		t.Errorf("Metadata access bumped frequency: %v != 2", f)
+	}
+}
+
+func TestIncrementOnSet(t *testing.T) {
+	c := New()
+	c.IncrementOnSet = false
+	c.Set("a", 1)
+	c.Set("a", 2)
+	c.MarkPersisted("a")
+	c.Set("a", 3)
+	if f := c.TotalFrequency(); f != 1 {
+		t.Errorf("Overwrite bumped frequency: %v != 1", f)
+	}
+	if dirty, _ := c.IsDirty("a"); !dirty {
+		t.Errorf("Overwrite did not mark the entry dirty")
+	}
+	if v := c.Get("a"); v != 3 {
+		t.Errorf("Value was not overwritten: %v != 3", v)
+	}
+	c.InitialFrequency = 4
+	c.Set("b", 1)
+	if f := c.TotalFrequency(); f != 6 {
+		t.Errorf("Insert did not start at InitialFrequency: %v != 6", f)
+	}
+}
+
+func TestGetAndRefresh(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	c := New()
+	c.now = func() time.Time { return now }
+	c.SetWithDeadline("lease", 1, now.Add(time.Second))
+	now = now.Add(500 * time.Millisecond)
+	if v, ok := c.GetAndRefresh("lease", time.Second); !ok || v != 1 {
+		t.Errorf("Lease was not found: %v, %v", v, ok)
+	}
+	if ttl, _ := c.GetTTL("lease"); ttl != 1500*time.Millisecond {
+		t.Errorf("Lease was not extended: %v", ttl)
+	}
+	now = now.Add(2 * time.Second)
+	if _, ok := c.GetAndRefresh("lease", time.Second); ok {
+		t.Error("Expired lease was renewed")
+	}
+	if l := c.Len(); l != 0 {
+		t.Errorf("Expired lease was not removed: %v != 0", l)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	c := New()
+	if n, created, err := c.Incr("n", 5); err != nil || !created || n != 5 {
+		t.Errorf("Counter was not created: %v, %v, %v", n, created, err)
+	}
+	if n, created, err := c.Incr("n", -2); err != nil || created || n != 3 {
+		t.Errorf("Counter was not incremented: %v, %v, %v", n, created, err)
+	}
+	if v := c.Get("n"); v != int64(3) {
+		t.Errorf("Stored value is wrong: %v", v)
+	}
+	c.Set("s", "x")
+	if _, _, err := c.Incr("s", 1); err != ErrNotInt64 {
+		t.Errorf("Non-counter was incremented: %v", err)
+	}
+	c.HardCap = 2
+	if n, created, err := c.Incr("m", 1); err != ErrNotStored || created || n != 0 {
+		t.Errorf("Rejected counter was reported created: %v, %v, %v", n, created, err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Get("a")
+	c.Set("b", 2)
+	if c.Rename("missing", "c") {
+		t.Errorf("Missing key was renamed")
+	}
+	if c.Rename("a", "b") {
+		t.Errorf("Existing key was overwritten")
+	}
+	c.RenameOverwrites = true
+	var replaced []Eviction
+	c.OnReplace = func(old Eviction) { replaced = append(replaced, old) }
+	if !c.Rename("a", "b") {
+		t.Errorf("Rename failed")
+	}
+	if len(replaced) != 1 || replaced[0].Key != "b" || replaced[0].Value != 2 {
+		t.Errorf("Overwritten entry was not reported: %v", replaced)
+	}
+	if c.Len() != 1 || c.Get("a") != nil {
+		t.Errorf("Old key is still present")
+	}
+	if f, _ := c.FrequencyOf("b"); f != 2 {
+		t.Errorf("Frequency was not kept: %v != 2", f)
+	}
+	if dirty, _ := c.IsDirty("b"); !dirty {
+		t.Errorf("Dirty state was not kept")
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	type boxed struct{ v interface{} }
+	ch := make(chan Eviction, 1)
+
+	c := New()
+	c.Encode = func(v interface{}) interface{} { return boxed{v} }
+	c.Decode = func(v interface{}) interface{} { return v.(boxed).v }
+	c.EvictionChannel = ch
+	c.Sizer = func(key string, value interface{}) int64 {
+		if _, ok := value.(boxed); !ok {
+			t.Errorf("Sizer saw the exposed form: %v", value)
+		}
+		return 1
+	}
+	c.Set("a", 1)
+	if v := c.Get("a"); v != 1 {
+		t.Errorf("Value was not decoded: %v", v)
+	}
+	c.Update("a", func(old interface{}) interface{} { return old.(int) + 1 })
+	if n, _, err := c.Incr("b", 1); err != nil || n != 1 {
+		t.Errorf("Incr failed: %v, %v", n, err)
+	}
+	if n, _, err := c.Incr("b", 1); err != nil || n != 2 {
+		t.Errorf("Incr did not see the decoded value: %v, %v", n, err)
+	}
+	c.Evict(1)
+	if ev := <-ch; ev.Value != 2 && ev.Value != int64(2) {
+		t.Errorf("Evicted value was not decoded: %v", ev.Value)
+	}
+}
+
+func TestLowestScoring(t *testing.T) {
+	bySize := func(key string, value interface{}, freq int, lastAccess time.Time) float64 {
+		return float64(freq) / float64(len(value.(string)))
+	}
+
+	c := New()
+	if _, ok := c.LowestScoring(bySize); ok {
+		t.Errorf("Empty cache returned an entry")
+	}
+	c.Set("a", "a")
+	c.Set("b", "bbbb")
+	c.Set("c", "cccccccc")
+	c.Get("c")
+	c.Get("c")
+	if ev, _ := c.LowestScoring(bySize); ev.Key != "b" {
+		t.Errorf("Wrong entry returned: %v", ev.Key)
+	}
+}
+
+func TestNilValueEviction(t *testing.T) {
+	ch := make(chan Eviction, 2)
+	wb := make(chan Eviction, 1)
+
+	c := New()
+	c.EvictionChannel = ch
+	c.WriteBackChannel = wb
+	c.Set("a", nil)
+	c.WriteBack(1)
+	if ev := <-wb; !ev.NilValue {
+		t.Errorf("Written back nil value was not flagged")
+	}
+	c.Set("a", nil)
+	c.Set("b", 1)
+	c.Evict(2)
+	for i := 0; i < 2; i++ {
+		if ev := <-ch; ev.NilValue != (ev.Key == "a") {
+			t.Errorf("NilValue is wrong: %+v", ev)
+		}
+	}
+}
+
+func TestSortedEntries(t *testing.T) {
+	c := New()
+	c.Set("d", 4)
+	c.Set("c", 3)
+	c.Set("b", 2)
+	c.Set("a", 1)
+	c.Get("d")
+	c.Get("b")
+	var keys string
+	for _, ev := range c.SortedEntries() {
+		keys += ev.Key
+	}
+	if keys != "acbd" {
+		t.Errorf("Entries are in the wrong order: %v", keys)
+	}
+	freqs := map[string]int{}
+	for _, e := range c.Entries() {
+		freqs[e.Key] = e.Freq
+	}
+	if len(freqs) != 4 || freqs["b"] != 2 || freqs["c"] != 1 {
+		t.Errorf("Wrong entries: %v", freqs)
+	}
+}
+
+func TestCoalesceWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	c := New()
+	c.now = func() time.Time { return now }
+	c.CoalesceWindow = time.Millisecond
+	c.Set("a", 1)
+	now = now.Add(time.Millisecond)
+	for i := 0; i < 100; i++ {
+		c.Get("a")
+	}
+	if f, _ := c.FrequencyOf("a"); f != 2 {
+		t.Errorf("Burst was not coalesced: %v != 2", f)
+	}
+	now = now.Add(time.Millisecond)
+	c.Get("a")
+	if f, _ := c.FrequencyOf("a"); f != 3 {
+		t.Errorf("Access after the window was not counted: %v != 3", f)
+	}
+}
+
+func TestDetailedStats(t *testing.T) {
+	c := New()
+	if ds := c.DetailedStats(); ds.P50 != 0 || ds.P99 != 0 {
+		t.Errorf("Empty cache has percentiles: %+v", ds)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("%v", i)
+		c.Set(key, i)
+		for j := 0; j < i/10; j++ {
+			c.Get(key)
+		}
+	}
+	c.Get("missing")
+	ds := c.DetailedStats()
+	if ds.P50 != 5 || ds.P90 != 9 || ds.P99 != 10 {
+		t.Errorf("Percentiles are wrong: %v, %v, %v", ds.P50, ds.P90, ds.P99)
+	}
+	if ds.Hits != 450 || ds.Misses != 1 {
+		t.Errorf("Hits and misses are wrong: %v, %v", ds.Hits, ds.Misses)
+	}
+}
+
+func TestMaxEvictPerSet(t *testing.T) {
+	c := New()
+	c.UpperBound = 10
+	c.LowerBound = 4
+	c.MaxEvictPerSet = 3
+	for i := 0; i < 11; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if n := c.Len(); n != 8 {
+		t.Errorf("Eviction was not limited: %v != 8", n)
+	}
+	for i, want := range []int{6, 4, 5} {
+		c.Set(fmt.Sprintf("x%v", i), i)
+		if n := c.Len(); n != want {
+			t.Errorf("Deferred eviction is wrong: %v != %v", n, want)
+		}
+	}
+}
+
+func TestDisableBounds(t *testing.T) {
+	c := New()
+	c.UpperBound = 10
+	c.LowerBound = 5
+	c.DisableBounds()
+	c.DisableBounds()
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if n := c.Len(); n != 20 {
+		t.Errorf("Entries were evicted with bounds disabled: %v != 20", n)
+	}
+	c.EnableBounds()
+	if n := c.Len(); n != 5 || c.UpperBound != 10 {
+		t.Errorf("Bounds were not restored: %v, %v", n, c.UpperBound)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("b")
+	if n := c.DeleteMany([]string{"a", "b", "missing", "a"}); n != 2 {
+		t.Errorf("Wrong number of keys deleted: %v != 2", n)
+	}
+	if c.Len() != 1 || c.BucketCount() != 1 {
+		t.Errorf("Cache was not cleaned up: %v, %v", c.Len(), c.BucketCount())
+	}
+}
+
+func TestOnReplace(t *testing.T) {
+	var replaced []interface{}
+
+	c := New()
+	c.OnReplace = func(old Eviction) {
+		replaced = append(replaced, old.Value)
+	}
+	c.Set("a", 1)
+	c.Set("a", 2)
+	c.Update("a", func(old interface{}) interface{} { return 3 })
+	if len(replaced) != 2 || replaced[0] != 1 || replaced[1] != 2 {
+		t.Errorf("Replaced values are wrong: %v", replaced)
+	}
+}
+
+func TestValueValidator(t *testing.T) {
+	errNotBytes := errors.New("not []byte")
+
+	c := New()
+	c.ValueValidator = func(value interface{}) error {
+		if _, ok := value.([]byte); !ok {
+			return errNotBytes
+		}
+		return nil
+	}
+	if err := c.SetChecked("a", "a"); err != errNotBytes {
+		t.Errorf("Invalid value was not rejected: %v", err)
+	}
+	c.Set("a", "a")
+	if c.Len() != 0 {
+		t.Errorf("Invalid value was stored")
+	}
+	if err := c.SetChecked("a", []byte("a")); err != nil {
+		t.Errorf("Valid value was rejected: %v", err)
+	}
+	c.Update("a", func(old interface{}) interface{} { return "b" })
+	if v, ok := c.Get("a").([]byte); !ok || string(v) != "a" {
+		t.Errorf("Update stored an invalid value: %v", c.Get("a"))
+	}
+	if c.SetAtomic(map[string]interface{}{"b": []byte("b"), "c": "c"}) {
+		t.Error("Batch with an invalid value was committed")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Partial batch was stored: %v", c.Len())
+	}
+}
+
+func TestSwapValue(t *testing.T) {
+	c := New()
+	if old, existed, err := c.SwapValue("a", 1); existed || old != nil || err != nil {
+		t.Errorf("Missing key existed: %v, %v, %v", old, existed, err)
+	}
+	c.MarkPersisted("a")
+	if old, existed, err := c.SwapValue("a", 2); !existed || old != 1 || err != nil {
+		t.Errorf("Wrong old value: %v, %v, %v", old, existed, err)
+	}
+	if dirty, _ := c.IsDirty("a"); !dirty {
+		t.Errorf("Swapped entry is not dirty")
+	}
+	c.IncrementOnSet = false
+	c.SwapValue("a", 3)
+	if f, _ := c.FrequencyOf("a"); f != 3 || c.Get("a") != 3 {
+		t.Errorf("Swap did not bump frequency: %v", f)
+	}
+	fail := errors.New("invalid")
+	c.ValueValidator = func(value interface{}) error { return fail }
+	if old, _, err := c.SwapValue("a", 4); err != fail || old != 3 || c.Get("a") != 3 {
+		t.Errorf("Rejected swap was applied: %v, %v", old, err)
+	}
+	c.ValueValidator = nil
+	c.HardCap = 1
+	if _, _, err := c.SwapValue("b", 1); err != ErrNotStored {
+		t.Errorf("Swap past HardCap was not reported: %v", err)
+	}
+}
+
+func TestFixedSize(t *testing.T) {
+	for _, tc := range []struct{ upper, lower int }{
+		{1, 1}, {2, 2}, {5, 5}, {5, 6},
+	} {
+		c := New()
+		c.UpperBound = tc.upper
+		c.LowerBound = tc.lower
+		for i := 0; i < 3*tc.upper; i++ {
+			c.Set(fmt.Sprintf("%v", i), i)
+			if n := c.Len(); n > tc.upper {
+				t.Errorf("%+v: length exceeded UpperBound: %v", tc, n)
+			}
+		}
+		if n := c.Len(); n != tc.upper {
+			t.Errorf("%+v: final length is wrong: %v != %v", tc, n, tc.upper)
+		}
+		if n := c.Stats().Evictions; n != int64(2*tc.upper) {
+			t.Errorf("%+v: wrong number of evictions: %v != %v", tc, n, 2*tc.upper)
+		}
+	}
+}
+
+func TestOnEmpty(t *testing.T) {
+	var fired int
+
+	c := New()
+	c.OnEmpty = func() { fired++ }
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Delete("a")
+	c.Delete("b")
+	c.Delete("b")
+	if fired != 1 {
+		t.Errorf("OnEmpty fired %v times on Delete", fired)
+	}
+	c.Set("a", 1)
+	c.Evict(5)
+	c.Set("a", 1)
+	c.Clear()
+	c.Clear()
+	if fired != 3 || c.Len() != 0 {
+		t.Errorf("OnEmpty fired %v times on Evict and Clear", fired)
+	}
+
+	fired = 0
+	c.HardCap = 1
+	c.HardCapEvict = true
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Seed([]SeededEntry{{Key: "c", Value: 4, Freq: 2}})
+	if fired != 0 || c.Len() != 1 {
+		t.Errorf("OnEmpty fired %v times while an entry was kept", fired)
+	}
+}
+
+func TestDirtyColdEntries(t *testing.T) {
+	c := New()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Set("d", 4)
+	c.Get("c")
+	c.MarkPersisted("a")
+	var keys string
+	for _, ev := range c.DirtyColdEntries(2) {
+		keys += ev.Key
+	}
+	if keys != "bd" {
+		t.Errorf("Wrong entries returned: %v", keys)
+	}
+	if f, _ := c.FrequencyOf("b"); f != 1 {
+		t.Errorf("Frequency was bumped: %v", f)
+	}
+}
+
+func TestSyncEvictAboveDirty(t *testing.T) {
+	ch := make(chan Eviction, 1)
+
+	c := New()
+	c.EvictionChannel = ch
+	c.SyncEvictAboveDirty = 3
+	c.SelfCheck = true
+	c.OnCorruption = func(err error) { t.Error(err) }
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Evict(2)
+	if n := c.Stats().SkippedEvictions; n != 1 {
+		t.Errorf("Eviction below the threshold was not dropped: %v", n)
+	}
+	if ok, reason := c.Healthy(); !ok {
+		t.Errorf("Intended drop made the cache unhealthy: %v", reason)
+	}
+	<-ch
+	for i := 0; i < 4; i++ {
+		c.Set(fmt.Sprintf("x%v", i), i)
+	}
+	done := make(chan bool)
+	go func() {
+		c.Evict(2)
+		done <- true
+	}()
+	<-ch
+	<-ch
+	<-done
+	if n := c.Stats().SkippedEvictions; n != 1 {
+		t.Errorf("Eviction above the threshold was dropped: %v", n)
+	}
+}
+
+func TestBulkChunk(t *testing.T) {
+	c := New()
+	c.BulkChunk = 3
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%v", i)
+		c.Set(keys[i], i)
+	}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			c.Get(keys[i%len(keys)])
+		}
+		done <- true
+	}()
+	if n := c.PinMany(keys); n != 10 {
+		t.Errorf("Wrong number of keys pinned: %v", n)
+	}
+	var ranged int
+	c.RangeOlderThan(-time.Hour, func(key string, value interface{}) bool {
+		ranged++
+		return true
+	})
+	if ranged != 10 {
+		t.Errorf("Wrong number of entries ranged over: %v", ranged)
+	}
+	if n := c.DeleteMany(keys); n != 10 || c.Len() != 0 {
+		t.Errorf("Wrong number of keys deleted: %v, %v", n, c.Len())
+	}
+	<-done
+}
+
+// pauseLock calls onPause, once, the first time it is unlocked.
+type pauseLock struct {
+	sync.Mutex
+	onPause func()
+}
+
+func (l *pauseLock) Unlock() {
+	l.Mutex.Unlock()
+	if fn := l.onPause; fn != nil {
+		l.onPause = nil
+		fn()
+	}
+}
+
+func TestBulkChunkRecheck(t *testing.T) {
+	now := time.Unix(1000, 0)
+	lock := new(pauseLock)
+
+	c := New()
+	c.lock = lock
+	c.now = func() time.Time { return now }
+	c.BulkChunk = 1
+	c.Set("a", 1)
+	c.Set("b", 2)
+	lock.onPause = func() { c.PinMany([]string{"a", "b"}) }
+	if n := c.EvictPrefix(""); n != 1 || c.Len() != 1 {
+		t.Errorf("Entry pinned during EvictPrefix was evicted: %v, %v", n, c.Len())
+	}
+	c.Set("c", 3)
+	now = now.Add(time.Hour)
+	lock.onPause = func() {
+		for _, key := range []string{"a", "b", "c"} {
+			c.Get(key)
+		}
+	}
+	var ranged int
+	c.RangeOlderThan(time.Minute, func(key string, value interface{}) bool {
+		ranged++
+		return true
+	})
+	if ranged != 1 {
+		t.Errorf("Entry read during RangeOlderThan was ranged over: %v", ranged)
+	}
+}
+
+func TestEvictionInterval(t *testing.T) {
+	var warnings []int64
+
+	c := New()
+	c.UpperBound = 10
+	c.LowerBound = 9
+	c.OscillationSets = 3
+	c.OnOscillation = func(interval int64) {
+		warnings = append(warnings, interval)
+	}
+	for i := 0; i < 11; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if st := c.Stats(); st.LastEvictionInterval != 11 || st.SetsSinceEviction != 0 {
+		t.Errorf("Wrong interval stats: %+v", st)
+	}
+	c.Set("x", 1)
+	if n := c.Stats().SetsSinceEviction; n != 1 {
+		t.Errorf("Sets since eviction is wrong: %v != 1", n)
+	}
+	c.Set("y", 1)
+	if n := c.Stats().LastEvictionInterval; n != 2 {
+		t.Errorf("Interval is wrong: %v != 2", n)
+	}
+	if len(warnings) != 1 || warnings[0] != 2 {
+		t.Errorf("Oscillation was not reported: %v", warnings)
+	}
+
+	warnings = nil
+	c = New()
+	c.UpperBound = 10
+	c.LowerBound = 2
+	c.MaxEvictPerSet = 2
+	c.OscillationSets = 3
+	c.OnOscillation = func(interval int64) {
+		warnings = append(warnings, interval)
+	}
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if c.Stats().LastEvictionInterval != 1 || len(warnings) != 0 {
+		t.Errorf("Drain was reported as oscillation: %v", warnings)
+	}
+}
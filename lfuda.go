@@ -0,0 +1,35 @@
+package lfu
+
+// NewLFUDA returns a Cache using LFU with dynamic aging.  The cache
+// keeps an age, which automatic eviction raises to the frequency of
+// each entry it evicts.  Entries are inserted at InitialFrequency plus
+// the age, and an access moves an entry to its access count plus the
+// age.  Keys that were hot long ago thus fall behind keys that are
+// hot now, without a separate decay pass.  Frequencies reported by
+// the cache include the age.
+func NewLFUDA() *Cache {
+	c := New()
+	c.dynamicAging = true
+	return c
+}
+
+// agedIncrement is increment for LFUDA caches.
+func (c *Cache) agedIncrement(e *cacheEntry) {
+	if e.freqNode == nil {
+		e.hits = c.initialFrequency()
+	} else {
+		e.hits++
+	}
+	freq := e.hits + c.age
+	if e.freqNode != nil {
+		// never move down, e.g. after Restore reset the hits
+		if cur := e.freqNode.Value.(*listEntry).freq; freq <= cur {
+			freq = cur + 1
+		}
+		c.remEntry(e.freqNode, e)
+	}
+	c.place(e, freq)
+	if c.MaxBuckets > 0 && c.freqs.Len() > c.MaxBuckets {
+		c.coalesce(e.freqNode)
+	}
+}
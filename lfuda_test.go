@@ -0,0 +1,44 @@
+package lfu
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLFUDA(t *testing.T) {
+	for _, aging := range []bool{false, true} {
+		c := New()
+		if aging {
+			c = NewLFUDA()
+		}
+		c.UpperBound = 4
+		c.LowerBound = 3
+		c.Set("old", 0)
+		for i := 0; i < 10; i++ {
+			c.Get("old")
+		}
+		// a shifting workload of keys each read a few times
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("%v", i)
+			c.Set(key, i)
+			c.Get(key)
+			c.Get(key)
+		}
+		if present := c.Get("old") != nil; present == aging {
+			t.Errorf("aging=%v: old hot key present=%v", aging, present)
+		}
+	}
+}
+
+func TestLFUDADemote(t *testing.T) {
+	c := NewLFUDA()
+	c.Set("a", 1)
+	for i := 0; i < 50; i++ {
+		c.Get("a")
+	}
+	c.Demote("a")
+	c.Get("a")
+	if f, _ := c.FrequencyOf("a"); f != 2 {
+		t.Errorf("Demoted entry jumped back: %v != 2", f)
+	}
+}
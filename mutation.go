@@ -0,0 +1,44 @@
+package lfu
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+)
+
+// checksum returns a hash of value for the types DetectMutation
+// supports: byte slices and maps.
+func checksum(value interface{}) (uint64, bool) {
+	h := fnv.New64a()
+	switch v := value.(type) {
+	case []byte:
+		h.Write(v)
+	default:
+		if value == nil || reflect.TypeOf(value).Kind() != reflect.Map {
+			return 0, false
+		}
+		// fmt prints maps with sorted keys
+		fmt.Fprintf(h, "%v", value)
+	}
+	return h.Sum64(), true
+}
+
+func (c *Cache) seal(e *cacheEntry) {
+	e.sealed = false
+	if c.DetectMutation {
+		e.checksum, e.sealed = checksum(e.value)
+	}
+}
+
+func (c *Cache) verify(e *cacheEntry) {
+	if !e.sealed {
+		return
+	}
+	if sum, _ := checksum(e.value); sum != e.checksum {
+		if c.OnMutation != nil {
+			c.OnMutation(e.key)
+			return
+		}
+		panic(fmt.Sprintf("lfu: value for key %q was mutated after Set", e.key))
+	}
+}
@@ -0,0 +1,36 @@
+package lfu
+
+import "time"
+
+// negative reports whether key is remembered as absent from the
+// backing store.
+func (c *Cache) negative(key string) bool {
+	until, ok := c.negatives[key]
+	if !ok {
+		return false
+	}
+	if c.now().Before(until) {
+		return true
+	}
+	delete(c.negatives, key)
+	return false
+}
+
+// addNegative remembers key as absent for WriteThrough.NegativeTTL.
+// Expired keys are swept whenever the set has doubled since the last
+// sweep, so keys that are never asked for again do not pile up.
+func (c *Cache) addNegative(key string) {
+	if c.negatives == nil {
+		c.negatives = make(map[string]time.Time)
+	}
+	now := c.now()
+	c.negatives[key] = now.Add(c.WriteThrough.NegativeTTL)
+	if len(c.negatives) > 2*c.negativesSwept {
+		for k, until := range c.negatives {
+			if !now.Before(until) {
+				delete(c.negatives, k)
+			}
+		}
+		c.negativesSwept = len(c.negatives)
+	}
+}
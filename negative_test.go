@@ -0,0 +1,41 @@
+package lfu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNegativeTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var loads int
+
+	c := New()
+	c.now = func() time.Time { return now }
+	c.WriteThrough.Load = func(key string) (interface{}, bool) {
+		loads++
+		if key == "present" {
+			return 1, true
+		}
+		return nil, false
+	}
+	c.WriteThrough.NegativeTTL = time.Second
+	c.WriteThrough.LoadTTL = time.Minute
+	c.Get("absent")
+	c.Get("absent")
+	if loads != 1 {
+		t.Errorf("Negative result was not cached: %v loads", loads)
+	}
+	now = now.Add(time.Second)
+	c.Get("absent")
+	if loads != 2 {
+		t.Errorf("Negative result did not expire: %v loads", loads)
+	}
+	c.Set("absent", 2)
+	if v := c.Get("absent"); v != 2 {
+		t.Errorf("Set did not clear the negative entry: %v", v)
+	}
+	c.Get("present")
+	if ttl, _ := c.GetTTL("present"); ttl != time.Minute {
+		t.Errorf("LoadTTL was not applied: %v", ttl)
+	}
+}
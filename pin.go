@@ -0,0 +1,83 @@
+package lfu
+
+// Pin protects the entry for key from eviction, both automatic and
+// through Evict and its variants, until it is unpinned.  Pinned entries
+// still count towards the bounds, still expire, and can be deleted.
+// If everything is pinned, the cache can grow past UpperBound and
+// MaxBytes, and HardCapEvict can no longer make room.  It returns false
+// if the key is not present.
+func (c *Cache) Pin(key string) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.setPinned(key, true)
+}
+
+// Unpin makes the entry for key evictable again.  It returns false if
+// the key is not present.
+func (c *Cache) Unpin(key string) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.setPinned(key, false)
+}
+
+// PinMany pins every present key in keys under a single lock
+// acquisition and returns the number found.
+func (c *Cache) PinMany(keys []string) int {
+	c.lock.Lock()
+	defer c.unlock()
+	var n int
+	for i, key := range keys {
+		c.pause(i)
+		if c.setPinned(key, true) {
+			n++
+		}
+	}
+	return n
+}
+
+// UnpinMany unpins every present key in keys under a single lock
+// acquisition and returns the number found.
+func (c *Cache) UnpinMany(keys []string) int {
+	c.lock.Lock()
+	defer c.unlock()
+	var n int
+	for i, key := range keys {
+		c.pause(i)
+		if c.setPinned(key, false) {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) setPinned(key string, pinned bool) bool {
+	e, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	if e.pinned != pinned {
+		e.pinned = pinned
+		if pinned {
+			c.pinned++
+		} else {
+			c.pinned--
+		}
+	}
+	return true
+}
+
+// PinnedEntries returns every pinned entry, without counting an access.
+func (c *Cache) PinnedEntries() []Eviction {
+	c.lock.Lock()
+	defer c.unlock()
+	pinned := make([]Eviction, 0, c.pinned)
+	if c.pinned == 0 {
+		return pinned
+	}
+	for _, e := range c.values {
+		if e.pinned && !c.expired(e) {
+			pinned = append(pinned, Eviction{Key: e.key, Value: c.exposed(e)})
+		}
+	}
+	return pinned
+}
@@ -0,0 +1,33 @@
+package lfu
+
+import (
+	"testing"
+)
+
+func TestPin(t *testing.T) {
+	c := New()
+	c.UpperBound = 3
+	c.LowerBound = 2
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if n := c.PinMany([]string{"a", "b", "missing"}); n != 2 {
+		t.Errorf("Wrong number of keys pinned: %v != 2", n)
+	}
+	c.Set("c", 3)
+	c.Set("d", 4)
+	if c.Get("a") == nil || c.Get("b") == nil {
+		t.Errorf("Pinned entries were evicted")
+	}
+	if n := c.Evict(10); n != 0 {
+		t.Errorf("Evict removed pinned entries: %v", n)
+	}
+	if pinned := c.PinnedEntries(); len(pinned) != 2 {
+		t.Errorf("Wrong pinned entries: %v", pinned)
+	}
+	if n := c.UnpinMany([]string{"a", "b"}); n != 2 {
+		t.Errorf("Wrong number of keys unpinned: %v != 2", n)
+	}
+	if n := c.Evict(10); n != 2 {
+		t.Errorf("Unpinned entries were not evicted: %v", n)
+	}
+}
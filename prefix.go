@@ -0,0 +1,75 @@
+package lfu
+
+import "strings"
+
+// PrefixStats holds counters for keys sharing a tracked prefix.
+type PrefixStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+// TrackPrefix starts counting hits, misses and evictions for keys
+// starting with prefix.  Keys matching several tracked prefixes count
+// towards each of them.
+func (c *Cache) TrackPrefix(prefix string) {
+	c.lock.Lock()
+	defer c.unlock()
+	if c.prefixes == nil {
+		c.prefixes = make(map[string]*PrefixStats)
+	}
+	if _, ok := c.prefixes[prefix]; !ok {
+		c.prefixes[prefix] = new(PrefixStats)
+	}
+}
+
+// StatsForPrefix returns the counters for a prefix registered with
+// TrackPrefix.
+func (c *Cache) StatsForPrefix(prefix string) (PrefixStats, bool) {
+	c.lock.Lock()
+	defer c.unlock()
+	if ps, ok := c.prefixes[prefix]; ok {
+		return *ps, true
+	}
+	return PrefixStats{}, false
+}
+
+// EvictPrefix evicts every entry whose key starts with prefix, except
+// pinned ones, reporting dirty entries on EvictionChannel as Evict
+// does.  It returns the number evicted.
+func (c *Cache) EvictPrefix(prefix string) int {
+	c.lock.Lock()
+	defer c.unlock()
+	var matches []*cacheEntry
+	for key, e := range c.values {
+		if strings.HasPrefix(key, prefix) && !e.pinned {
+			matches = append(matches, e)
+		}
+	}
+	var evicted int
+	for i, e := range matches {
+		c.pause(i)
+		if c.values[e.key] != e || e.pinned {
+			continue
+		}
+		if c.expired(e) {
+			c.expire(e)
+			continue
+		}
+		c.evictEntry(e, ReasonManual)
+		evicted++
+	}
+	return evicted
+}
+
+func (c *Cache) countPrefix(key string, fn func(*PrefixStats)) {
+	for prefix, ps := range c.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			fn(ps)
+		}
+	}
+}
+
+func countHit(ps *PrefixStats)      { ps.Hits++ }
+func countMiss(ps *PrefixStats)     { ps.Misses++ }
+func countEviction(ps *PrefixStats) { ps.Evictions++ }
@@ -0,0 +1,45 @@
+package lfu
+
+import "testing"
+
+func TestTrackPrefix(t *testing.T) {
+	c := New()
+	c.TrackPrefix("t1:")
+	c.Set("t1:a", 1)
+	c.Set("t2:a", 2)
+	c.Get("t1:a")
+	c.Get("t1:b")
+	c.Get("t2:a")
+	c.Evict(2)
+
+	ps, ok := c.StatsForPrefix("t1:")
+	if !ok {
+		t.Fatal("Tracked prefix was not found")
+	}
+	if ps.Hits != 1 || ps.Misses != 1 || ps.Evictions != 1 {
+		t.Errorf("Prefix stats are wrong: %+v", ps)
+	}
+	if _, ok := c.StatsForPrefix("t2:"); ok {
+		t.Error("Untracked prefix was found")
+	}
+}
+
+func TestEvictPrefix(t *testing.T) {
+	ch := make(chan Eviction, 2)
+
+	c := New()
+	c.EvictionChannel = ch
+	c.Set("t1:a", 1)
+	c.Set("t1:b", 2)
+	c.Set("t2:a", 3)
+	c.Get("t1:b")
+	if n := c.EvictPrefix("t1:"); n != 2 {
+		t.Errorf("Wrong number of entries evicted: %v != 2", n)
+	}
+	if len(ch) != 2 {
+		t.Errorf("Evictions were not reported: %v", len(ch))
+	}
+	if c.Len() != 1 || c.BucketCount() != 1 || c.Get("t2:a") != 3 {
+		t.Errorf("Wrong entries left: %v, %v", c.Len(), c.BucketCount())
+	}
+}
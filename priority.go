@@ -0,0 +1,136 @@
+package lfu
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+)
+
+// SetWithPriority is like Set, but also assigns the entry a priority
+// class.  Eviction drains lower classes before touching higher ones,
+// and only within a class goes by frequency.  Entries stored with Set
+// are in class 0 unless given another class here.  Priorities do not
+// affect the frequency list itself; eviction skips over entries of
+// higher classes, which shows up in Stats.LastEvictScanned.
+func (c *Cache) SetWithPriority(key string, value interface{}, prio int) {
+	c.lock.Lock()
+	defer c.unlock()
+	if e, _ := c.store(context.Background(), key, value); e != nil {
+		c.setPriority(e, prio)
+	}
+}
+
+func (c *Cache) setPriority(e *cacheEntry, prio int) {
+	if e.prio != 0 {
+		if c.prios[e.prio]--; c.prios[e.prio] == 0 {
+			delete(c.prios, e.prio)
+		}
+	}
+	e.prio = prio
+	if prio != 0 {
+		if c.prios == nil {
+			c.prios = make(map[int]int)
+		}
+		c.prios[prio]++
+	}
+}
+
+// priorityClasses returns the priority classes in use, lowest first.
+func (c *Cache) priorityClasses() []int {
+	if len(c.prios) == 0 {
+		return []int{0}
+	}
+	classes := make([]int, 0, len(c.prios)+1)
+	others := 0
+	for prio, n := range c.prios {
+		classes = append(classes, prio)
+		others += n
+	}
+	if others < c.len {
+		classes = append(classes, 0)
+	}
+	sort.Ints(classes)
+	return classes
+}
+
+// victims returns up to count entries in the order eviction would
+// remove them, along with the number of buckets and entries examined.
+func (c *Cache) victims(count int) (victims []*cacheEntry, buckets, scanned int) {
+	for _, prio := range c.priorityClasses() {
+		prio := prio
+		eligible := func(e *cacheEntry) bool { return e.prio == prio && !e.pinned }
+		for place := c.freqs.Front(); place != nil && len(victims) < count; place = place.Next() {
+			buckets++
+			li := place.Value.(*listEntry)
+			if c.PreferCleanEviction {
+				// clean entries first, then dirty ones
+				victims = c.bucketVictims(li, count, victims, &scanned, func(e *cacheEntry) bool {
+					return eligible(e) && e.persisted
+				})
+				victims = c.bucketVictims(li, count, victims, &scanned, func(e *cacheEntry) bool {
+					return eligible(e) && !e.persisted
+				})
+				continue
+			}
+			victims = c.bucketVictims(li, count, victims, &scanned, eligible)
+		}
+	}
+	return victims, buckets, scanned
+}
+
+// bucketVictims appends entries of li that match to victims until it
+// holds count, least recently used first or, under TieBreakFIFO,
+// earliest inserted first.
+func (c *Cache) bucketVictims(li *listEntry, count int, victims []*cacheEntry, scanned *int, match func(*cacheEntry) bool) []*cacheEntry {
+	if c.TieBreakFIFO {
+		return append(victims, c.oldest(li, count-len(victims), scanned, match)...)
+	}
+	for e := li.entries.Front(); e != nil && len(victims) < count; e = e.next {
+		*scanned++
+		if match(e) {
+			victims = append(victims, e)
+		}
+	}
+	return victims
+}
+
+// oldest returns the n earliest inserted entries of li that match,
+// earliest first.  It keeps the n best seen so far in a heap, so a
+// single pass suffices and only those n are sorted.
+func (c *Cache) oldest(li *listEntry, n int, scanned *int, match func(*cacheEntry) bool) []*cacheEntry {
+	if n > li.entries.Len() {
+		n = li.entries.Len()
+	}
+	if n <= 0 {
+		return nil
+	}
+	h := make(seqHeap, 0, n)
+	for e := li.entries.Front(); e != nil; e = e.next {
+		*scanned++
+		if !match(e) {
+			continue
+		}
+		if len(h) < n {
+			heap.Push(&h, e)
+		} else if e.seq < h[0].seq {
+			h[0] = e
+			heap.Fix(&h, 0)
+		}
+	}
+	sort.Slice(h, func(i, j int) bool { return h[i].seq < h[j].seq })
+	return h
+}
+
+// seqHeap is a max-heap of entries by seq.
+type seqHeap []*cacheEntry
+
+func (h seqHeap) Len() int            { return len(h) }
+func (h seqHeap) Less(i, j int) bool  { return h[i].seq > h[j].seq }
+func (h seqHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *seqHeap) Push(x interface{}) { *h = append(*h, x.(*cacheEntry)) }
+func (h *seqHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
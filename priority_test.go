@@ -0,0 +1,100 @@
+package lfu
+
+import "testing"
+
+func TestSetWithPriority(t *testing.T) {
+	c := New()
+	c.SetWithPriority("high", 1, 2)
+	c.SetWithPriority("low", 2, -1)
+	c.Set("normal", 3)
+	c.Get("low")
+	c.Get("low")
+
+	preview := c.EvictionPreview(3)
+	for i, key := range []string{"low", "normal", "high"} {
+		if preview[i].Key != key {
+			t.Errorf("Wrong order at %v: %v != %v", i, preview[i].Key, key)
+		}
+	}
+	if ev, _ := c.NextVictim(); ev.Key != "low" {
+		t.Errorf("Wrong victim: %v", ev.Key)
+	}
+
+	c.Evict(2)
+	if v := c.Get("high"); v != 1 {
+		t.Errorf("High priority entry was evicted: %v", v)
+	}
+	if len(c.prios) != 1 || c.prios[2] != 1 {
+		t.Errorf("Priority counts are wrong: %v", c.prios)
+	}
+}
+
+func TestTieBreakFIFO(t *testing.T) {
+	for _, fifo := range []bool{false, true} {
+		c := New()
+		c.TieBreakFIFO = fifo
+		c.Set("a", 1)
+		c.Set("b", 2)
+		// both end up at frequency 2, b promoted first
+		c.Get("b")
+		c.Get("a")
+		want := "b"
+		if fifo {
+			want = "a"
+		}
+		if ev, _ := c.NextVictim(); ev.Key != want {
+			t.Errorf("TieBreakFIFO=%v: expected victim %v, got %v", fifo, want, ev.Key)
+		}
+		c.Evict(1)
+		if c.Get(want) != nil {
+			t.Errorf("TieBreakFIFO=%v: expected %v to be evicted", fifo, want)
+		}
+	}
+}
+
+func TestPreferCleanEviction(t *testing.T) {
+	ch := make(chan Eviction, 4)
+
+	c := New()
+	c.EvictionChannel = ch
+	c.PreferCleanEviction = true
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Set("d", 4)
+	c.Get("d")
+	c.MarkPersisted("b")
+	c.MarkPersisted("d")
+	if n := c.Evict(2); n != 2 {
+		t.Errorf("Wrong number of entries evicted: %v != 2", n)
+	}
+	// b goes first although it is not the least recently used, then
+	// a as the oldest dirty entry
+	if c.Get("b") != nil || c.Get("a") != nil || c.Get("c") != 3 {
+		t.Errorf("Wrong entries evicted")
+	}
+	if ev := <-ch; ev.Key != "a" || len(ch) != 0 {
+		t.Errorf("Wrong dirty evictions: %v", ev.Key)
+	}
+
+	// with TieBreakFIFO, clean entries go in insertion order, then
+	// dirty ones
+	c = New()
+	c.PreferCleanEviction = true
+	c.TieBreakFIFO = true
+	for _, key := range []string{"a", "b", "c", "d"} {
+		c.Set(key, key)
+	}
+	c.Get("a")
+	c.Get("b")
+	c.Demote("b")
+	c.Demote("a")
+	c.MarkPersisted("a")
+	c.MarkPersisted("b")
+	for _, want := range []string{"a", "b", "c"} {
+		if ev, ok := c.NextVictim(); !ok || ev.Key != want {
+			t.Errorf("Wrong victim: %v != %v", ev.Key, want)
+		}
+		c.Evict(1)
+	}
+}
@@ -0,0 +1,71 @@
+package lfu
+
+import (
+	"math"
+	"time"
+)
+
+// evictionRateWindow is the time constant of EvictionRate: an eviction
+// weighs 1/e as much after this long.
+const evictionRateWindow = 10 * time.Second
+
+// EvictionRate returns an exponentially weighted moving average of
+// the evictions counted in Stats.Evictions per second, with a time
+// constant of 10 seconds.  It is timed with the cache's clock.
+func (c *Cache) EvictionRate() float64 {
+	c.lock.Lock()
+	defer c.unlock()
+	return c.decayedRate(c.now())
+}
+
+func (c *Cache) decayedRate(now time.Time) float64 {
+	if c.rateAt.IsZero() {
+		return 0
+	}
+	dt := now.Sub(c.rateAt).Seconds()
+	return c.rate * math.Exp(-dt/evictionRateWindow.Seconds())
+}
+
+// countEvictionRate adds an eviction to EvictionRate.
+func (c *Cache) countEvictionRate() {
+	now := c.now()
+	c.rate = c.decayedRate(now) + 1/evictionRateWindow.Seconds()
+	c.rateAt = now
+}
+
+// RecentHitRatio returns the fraction of the last HitWindow Gets that
+// were hits, or 0 if HitWindow is 0 or there have been no Gets since it
+// was set.
+func (c *Cache) RecentHitRatio() float64 {
+	c.lock.Lock()
+	defer c.unlock()
+	n := c.hitNext
+	if c.hitFull {
+		n = len(c.hitRing)
+	}
+	if n == 0 || len(c.hitRing) != c.HitWindow {
+		return 0
+	}
+	var hits int
+	for _, hit := range c.hitRing[:n] {
+		if hit {
+			hits++
+		}
+	}
+	return float64(hits) / float64(n)
+}
+
+// countHitRatio records a Get for RecentHitRatio.
+func (c *Cache) countHitRatio(hit bool) {
+	if c.HitWindow <= 0 {
+		return
+	}
+	if len(c.hitRing) != c.HitWindow {
+		c.hitRing = make([]bool, c.HitWindow)
+		c.hitNext, c.hitFull = 0, false
+	}
+	c.hitRing[c.hitNext] = hit
+	if c.hitNext++; c.hitNext == len(c.hitRing) {
+		c.hitNext, c.hitFull = 0, true
+	}
+}
@@ -0,0 +1,51 @@
+package lfu
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEvictionRate(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	c := New()
+	c.now = func() time.Time { return now }
+	if r := c.EvictionRate(); r != 0 {
+		t.Errorf("Rate without evictions: %v != 0", r)
+	}
+	// a steady 10 evictions per second
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+		c.Evict(1)
+		now = now.Add(100 * time.Millisecond)
+	}
+	if r := c.EvictionRate(); math.Abs(r-10) > 0.5 {
+		t.Errorf("Steady rate is wrong: %v", r)
+	}
+	now = now.Add(time.Minute)
+	if r := c.EvictionRate(); r > 0.1 {
+		t.Errorf("Rate did not decay: %v", r)
+	}
+}
+
+func TestRecentHitRatio(t *testing.T) {
+	c := New()
+	c.HitWindow = 4
+	if r := c.RecentHitRatio(); r != 0 {
+		t.Errorf("Ratio without Gets: %v != 0", r)
+	}
+	c.Set("a", 1)
+	c.Get("a")
+	c.Get("b")
+	if r := c.RecentHitRatio(); r != 0.5 {
+		t.Errorf("Ratio is wrong: %v != 0.5", r)
+	}
+	for i := 0; i < 4; i++ {
+		c.Get("b")
+	}
+	if r := c.RecentHitRatio(); r != 0 {
+		t.Errorf("Old hits are still counted: %v != 0", r)
+	}
+}
@@ -0,0 +1,33 @@
+package lfu
+
+import "fmt"
+
+// selfCheck runs the cheap invariant checks enabled by SelfCheck and
+// reports a failure to OnCorruption.
+func (c *Cache) selfCheck() {
+	if !c.SelfCheck {
+		return
+	}
+	if err := c.checkInvariants(); err != nil && c.OnCorruption != nil {
+		c.call(func() { c.OnCorruption(err) })
+	}
+}
+
+func (c *Cache) checkInvariants() error {
+	if c.len != len(c.values) {
+		return fmt.Errorf("lfu: len is %d but %d entries are indexed", c.len, len(c.values))
+	}
+	if c.len > 0 && c.freqs.Front() == nil {
+		return fmt.Errorf("lfu: len is %d but there are no frequency buckets", c.len)
+	}
+	if c.len == 0 && c.freqs.Front() != nil {
+		return fmt.Errorf("lfu: cache is empty but has %d frequency buckets", c.freqs.Len())
+	}
+	if c.dirty < 0 || c.dirty > c.len {
+		return fmt.Errorf("lfu: %d of %d entries are dirty", c.dirty, c.len)
+	}
+	if len(c.buckets) != c.freqs.Len() {
+		return fmt.Errorf("lfu: %d frequency buckets but %d are indexed", c.freqs.Len(), len(c.buckets))
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package lfu
+
+import "testing"
+
+func TestSelfCheck(t *testing.T) {
+	var errs []error
+
+	c := New()
+	c.SelfCheck = true
+	c.OnCorruption = func(err error) {
+		errs = append(errs, err)
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Delete("a")
+	c.Evict(1)
+	if len(errs) != 0 {
+		t.Errorf("Healthy cache reported corruption: %v", errs)
+	}
+
+	c.Set("a", 1)
+	c.len++
+	c.Set("b", 2)
+	if len(errs) == 0 {
+		t.Error("Corruption was not reported")
+	}
+}
@@ -0,0 +1,91 @@
+package lfu
+
+import "hash/fnv"
+
+// ShardedCache spreads keys over several independent Caches by hash,
+// so that operations on different shards do not contend for a lock.
+// Each shard evicts on its own; bounds and other settings apply per
+// shard.
+type ShardedCache struct {
+	shards []*Cache
+}
+
+// NewSharded returns a ShardedCache of n shards (at least 1).  If init
+// is not nil it is called on every shard to configure it before use.
+func NewSharded(n int, init func(c *Cache)) *ShardedCache {
+	if n < 1 {
+		n = 1
+	}
+	s := &ShardedCache{shards: make([]*Cache, n)}
+	for i := range s.shards {
+		s.shards[i] = New()
+		if init != nil {
+			init(s.shards[i])
+		}
+	}
+	return s
+}
+
+func (s *ShardedCache) shard(key string) *Cache {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *ShardedCache) Get(key string) interface{} {
+	return s.shard(key).Get(key)
+}
+
+func (s *ShardedCache) Set(key string, value interface{}) {
+	s.shard(key).Set(key, value)
+}
+
+func (s *ShardedCache) Delete(key string) {
+	s.shard(key).Delete(key)
+}
+
+// Len returns the total number of entries over all shards.
+func (s *ShardedCache) Len() int {
+	var n int
+	for _, c := range s.shards {
+		n += c.Len()
+	}
+	return n
+}
+
+// ShardStats returns the Stats of every shard, in shard order,
+// followed by their sum.  The LastEvict fields of the sum are zero.
+// Shards are read one at a time, so the sum is not an atomic snapshot.
+func (s *ShardedCache) ShardStats() []CacheStats {
+	stats := make([]CacheStats, len(s.shards)+1)
+	total := &stats[len(s.shards)]
+	for i, c := range s.shards {
+		st := c.Stats()
+		stats[i] = st
+		total.Hits += st.Hits
+		total.Misses += st.Misses
+		total.Evictions += st.Evictions
+		total.BytesEvicted += st.BytesEvicted
+		total.DirtyEvictions += st.DirtyEvictions
+		total.DroppedEvictions += st.DroppedEvictions
+		total.SkippedEvictions += st.SkippedEvictions
+	}
+	return stats
+}
+
+// ShardLens returns the smallest, largest and average shard length,
+// to spot keys hashing unevenly.
+func (s *ShardedCache) ShardLens() (min, max int, avg float64) {
+	var total int
+	for i, c := range s.shards {
+		n := c.Len()
+		if i == 0 || n < min {
+			min = n
+		}
+		if n > max {
+			max = n
+		}
+		total += n
+	}
+	return min, max, float64(total) / float64(len(s.shards))
+}
@@ -0,0 +1,37 @@
+package lfu
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardStats(t *testing.T) {
+	s := NewSharded(4, func(c *Cache) {
+		c.UpperBound = 10
+		c.LowerBound = 5
+	})
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("%v", i)
+		s.Set(key, i)
+		s.Get(key)
+	}
+	s.Get("missing")
+	stats := s.ShardStats()
+	if len(stats) != 5 {
+		t.Fatalf("Wrong number of stats: %v != 5", len(stats))
+	}
+	total := stats[4]
+	var evictions int64
+	for _, st := range stats[:4] {
+		evictions += st.Evictions
+	}
+	if total.Evictions != evictions || total.Evictions != int64(100-s.Len()) {
+		t.Errorf("Evictions do not add up: %v, %v, %v", total.Evictions, evictions, s.Len())
+	}
+	if total.Hits+total.Misses != 101 {
+		t.Errorf("Gets do not add up: %v + %v != 101", total.Hits, total.Misses)
+	}
+	if min, max, avg := s.ShardLens(); min > max || avg != float64(s.Len())/4 {
+		t.Errorf("Shard lengths are wrong: %v, %v, %v", min, max, avg)
+	}
+}
@@ -0,0 +1,357 @@
+package lfu
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+type snapshotEntry struct {
+	Key       string
+	Value     interface{}
+	Freq      int
+	Persisted bool
+	ExpireAt  time.Time
+}
+
+// Snapshot writes the contents of the cache to w, coldest first, as a
+// gob stream.  Concrete value types must be registered with
+// gob.Register.
+func (c *Cache) Snapshot(w io.Writer) error {
+	return c.snapshot(w, false)
+}
+
+// SnapshotNormalized is like Snapshot, but rescales frequencies to
+// 1..n, where n is the number of distinct frequencies.  Relative
+// order, which is all that matters for eviction, is preserved.
+func (c *Cache) SnapshotNormalized(w io.Writer) error {
+	return c.snapshot(w, true)
+}
+
+func (c *Cache) snapshot(w io.Writer, normalize bool) error {
+	c.lock.Lock()
+	entries := make([]snapshotEntry, 0, c.len)
+	rank := 0
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		li := place.Value.(*listEntry)
+		rank++
+		freq := li.freq
+		if normalize {
+			freq = rank
+		}
+		for e := li.entries.Front(); e != nil; e = e.next {
+			entries = append(entries, snapshotEntry{
+				Key:       e.key,
+				Value:     c.exposed(e),
+				Freq:      freq,
+				Persisted: e.persisted,
+				ExpireAt:  e.expireAt,
+			})
+		}
+	}
+	c.lock.Unlock()
+	return gob.NewEncoder(w).Encode(entries)
+}
+
+// Restore replaces the contents of the cache with a snapshot read from
+// r.  Bounds are not enforced during restore.
+func (c *Cache) Restore(r io.Reader) error {
+	var entries []snapshotEntry
+	if err := gob.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+	c.restore(entries)
+	return nil
+}
+
+func (c *Cache) restore(entries []snapshotEntry) {
+	c.lock.Lock()
+	defer c.unlock()
+	c.reset(len(entries))
+	now := c.now()
+	for _, se := range entries {
+		if _, ok := c.values[se.Key]; ok {
+			continue
+		}
+		e := &cacheEntry{
+			key:        se.Key,
+			value:      c.encode(se.Value),
+			persisted:  se.Persisted,
+			expireAt:   se.ExpireAt,
+			createdAt:  now,
+			lastAccess: now,
+			seq:        c.nextSeq(),
+		}
+		c.resize(e)
+		c.values[se.Key] = e
+		c.place(e, se.Freq)
+		c.added(e)
+	}
+	c.selfCheck()
+}
+
+// SeededEntry is an entry with a known frequency, for use with Seed.
+type SeededEntry struct {
+	Key   string
+	Value interface{}
+	Freq  int
+}
+
+// Seed inserts entries at their given frequencies, replacing existing
+// entries with the same key and reporting them to OnReplace.  Bounds are enforced once, after all
+// entries are inserted.
+func (c *Cache) Seed(entries []SeededEntry) {
+	sorted := make([]SeededEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Freq < sorted[j].Freq
+	})
+
+	c.lock.Lock()
+	defer c.unlock()
+	now := c.now()
+	at := c.freqs.Front()
+	for _, se := range sorted {
+		if old, ok := c.values[se.Key]; ok {
+			if old.freqNode == at && old.freqNode.Value.(*listEntry).entries.Len() == 1 {
+				at = at.Next()
+			}
+			c.replaced(old)
+			c.delete(old)
+		}
+		freq := se.Freq
+		if freq < 1 {
+			freq = 1
+		}
+		for at != nil && at.Value.(*listEntry).freq < freq {
+			at = at.Next()
+		}
+		if at == nil || at.Value.(*listEntry).freq != freq {
+			li := new(listEntry)
+			li.freq = freq
+			if at == nil {
+				at = c.freqs.PushBack(li)
+			} else {
+				at = c.freqs.InsertBefore(li, at)
+			}
+			c.buckets[freq] = at
+		}
+		e := &cacheEntry{key: se.Key, value: c.encode(se.Value), createdAt: now, lastAccess: now, seq: c.nextSeq()}
+		c.seal(e)
+		c.resize(e)
+		c.values[se.Key] = e
+		e.freqNode = at
+		at.Value.(*listEntry).entries.PushBack(e)
+		c.added(e)
+	}
+	c.enforceBounds()
+	c.selfCheck()
+}
+
+// ErrUnsorted is returned by RestoreSorted if its entries are not in
+// ascending order of frequency.
+var ErrUnsorted = errors.New("lfu: entries not sorted by frequency")
+
+// RestoreSorted replaces the contents of the cache with entries, which
+// must be sorted by ascending frequency.  The buckets are then built
+// in a single pass, without the searches Seed has to do.  If entries
+// are out of order it returns ErrUnsorted and leaves the cache
+// unchanged.  Of duplicate keys the first wins.  Bounds are not
+// enforced.
+func (c *Cache) RestoreSorted(entries []SeededEntry) error {
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Freq < entries[i-1].Freq {
+			return ErrUnsorted
+		}
+	}
+	c.lock.Lock()
+	defer c.unlock()
+	c.reset(len(entries))
+	now := c.now()
+	for _, se := range entries {
+		if _, ok := c.values[se.Key]; ok {
+			continue
+		}
+		freq := se.Freq
+		if freq < 1 {
+			freq = 1
+		}
+		at := c.freqs.Back()
+		if at == nil || at.Value.(*listEntry).freq != freq {
+			at = c.freqs.PushBack(&listEntry{freq: freq})
+			c.buckets[freq] = at
+		}
+		e := &cacheEntry{key: se.Key, value: c.encode(se.Value), createdAt: now, lastAccess: now, seq: c.nextSeq()}
+		c.seal(e)
+		c.resize(e)
+		c.values[se.Key] = e
+		e.freqNode = at
+		at.Value.(*listEntry).entries.PushBack(e)
+		c.added(e)
+	}
+	c.selfCheck()
+	return nil
+}
+
+// SnapshotBinary writes the contents of the cache to w, coldest first,
+// in a compact binary format: for every entry, the key length, key,
+// frequency, value length and value, with the integers as uvarints.
+// Values are serialized with encode.  Only keys, values and
+// frequencies are preserved.
+func (c *Cache) SnapshotBinary(w io.Writer, encode func(interface{}) ([]byte, error)) error {
+	c.lock.Lock()
+	entries := make([]snapshotEntry, 0, c.len)
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		li := place.Value.(*listEntry)
+		for e := li.entries.Front(); e != nil; e = e.next {
+			entries = append(entries, snapshotEntry{Key: e.key, Value: c.exposed(e), Freq: li.freq})
+		}
+	}
+	c.lock.Unlock()
+
+	bw := bufio.NewWriter(w)
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v uint64) error {
+		_, err := bw.Write(buf[:binary.PutUvarint(buf[:], v)])
+		return err
+	}
+	for _, se := range entries {
+		value, err := encode(se.Value)
+		if err != nil {
+			return err
+		}
+		if err := writeUvarint(uint64(len(se.Key))); err != nil {
+			return err
+		}
+		if _, err := bw.WriteString(se.Key); err != nil {
+			return err
+		}
+		if err := writeUvarint(uint64(se.Freq)); err != nil {
+			return err
+		}
+		if err := writeUvarint(uint64(len(value))); err != nil {
+			return err
+		}
+		if _, err := bw.Write(value); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// RestoreBinary replaces the contents of the cache with a snapshot
+// written by SnapshotBinary, decoding values with decode.  The cache is
+// left unchanged if the snapshot cannot be read.  Bounds are not
+// enforced during restore.
+func (c *Cache) RestoreBinary(r io.Reader, decode func([]byte) (interface{}, error)) error {
+	br := bufio.NewReader(r)
+	var entries []snapshotEntry
+	for {
+		keyLen, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		key, err := readField(br, keyLen)
+		if err != nil {
+			return err
+		}
+		freq, err := binary.ReadUvarint(br)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		valueLen, err := binary.ReadUvarint(br)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		raw, err := readField(br, valueLen)
+		if err != nil {
+			return err
+		}
+		value, err := decode(raw)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, snapshotEntry{Key: string(key), Value: value, Freq: int(freq)})
+	}
+	c.restore(entries)
+	return nil
+}
+
+// readField reads an n byte key or value.  The buffer grows as data
+// arrives, so a corrupt length fails with io.ErrUnexpectedEOF instead
+// of allocating n bytes up front.
+func readField(r io.Reader, n uint64) ([]byte, error) {
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("lfu: snapshot field length %d out of range", n)
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// unexpectedEOF reports a snapshot that ends in the middle of an entry.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Rebalance checks that the frequency buckets are non-empty and in
+// strictly increasing order, and rebuilds them from the entries'
+// frequencies if not.  It reports whether a rebuild was needed.
+func (c *Cache) Rebalance() bool {
+	c.lock.Lock()
+	defer c.unlock()
+	clean := true
+	prev := 0
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		li := place.Value.(*listEntry)
+		if li.entries.Len() == 0 || li.freq <= prev {
+			clean = false
+			break
+		}
+		prev = li.freq
+	}
+	if clean {
+		return false
+	}
+
+	byFreq := make(map[int][]*cacheEntry)
+	var freqs []int
+	for place := c.freqs.Front(); place != nil; place = place.Next() {
+		li := place.Value.(*listEntry)
+		for e := li.entries.Front(); e != nil; e = e.next {
+			if _, ok := byFreq[li.freq]; !ok {
+				freqs = append(freqs, li.freq)
+			}
+			byFreq[li.freq] = append(byFreq[li.freq], e)
+		}
+	}
+	sort.Ints(freqs)
+	c.freqs = list.New()
+	c.buckets = make(map[int]*list.Element, len(freqs))
+	for _, freq := range freqs {
+		li := &listEntry{freq: freq}
+		place := c.freqs.PushBack(li)
+		c.buckets[freq] = place
+		for _, e := range byFreq[freq] {
+			e.freqNode = place
+			li.entries.PushBack(e)
+		}
+	}
+	return true
+}
@@ -0,0 +1,205 @@
+package lfu
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	c := New()
+	c.Set("a", "a")
+	c.Set("b", "b")
+	for i := 0; i < 100; i++ {
+		c.Get("b")
+	}
+
+	var raw, normalized bytes.Buffer
+	if err := c.Snapshot(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SnapshotNormalized(&normalized); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New()
+	if err := r.Restore(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if f := r.TotalFrequency(); f != 102 {
+		t.Errorf("Frequencies were not restored: %v != 102", f)
+	}
+
+	n := New()
+	if err := n.Restore(&normalized); err != nil {
+		t.Fatal(err)
+	}
+	if f := n.TotalFrequency(); f != 3 {
+		t.Errorf("Frequencies were not normalized: %v != 3", f)
+	}
+	if l := n.Len(); l != 2 {
+		t.Errorf("Length was not restored: %v != 2", l)
+	}
+	n.Evict(1)
+	if v := n.Get("b"); v != "b" {
+		t.Errorf("Relative order was not preserved: %v != 'b'", v)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	var replaced []Eviction
+
+	c := New()
+	c.OnReplace = func(old Eviction) { replaced = append(replaced, old) }
+	c.Set("a", "old")
+	c.Seed([]SeededEntry{
+		{Key: "c", Value: "c", Freq: 5},
+		{Key: "a", Value: "a", Freq: 3},
+		{Key: "b", Value: "b", Freq: 1},
+		{Key: "d", Value: "d", Freq: 3},
+	})
+	if l := c.Len(); l != 4 {
+		t.Errorf("Length is wrong: %v != 4", l)
+	}
+	if len(replaced) != 1 || replaced[0].Key != "a" || replaced[0].Value != "old" {
+		t.Errorf("Replaced entry was not reported: %v", replaced)
+	}
+	if f := c.TotalFrequency(); f != 12 {
+		t.Errorf("Frequencies were not seeded: %v != 12", f)
+	}
+	if n := c.BucketCount(); n != 3 {
+		t.Errorf("Bucket count is wrong: %v != 3", n)
+	}
+	for i, ev := range c.EvictionPreview(4) {
+		if key := []string{"b", "a", "d", "c"}[i]; ev.Key != key {
+			t.Errorf("Wrong order at %v: %v != %v", i, ev.Key, key)
+		}
+	}
+	if v := c.Get("a"); v != "a" {
+		t.Errorf("Existing key was not replaced: %v", v)
+	}
+}
+
+func TestSnapshotBinary(t *testing.T) {
+	encode := func(v interface{}) ([]byte, error) {
+		return []byte(v.(string)), nil
+	}
+	decode := func(b []byte) (interface{}, error) {
+		return string(b), nil
+	}
+
+	c := New()
+	c.Set("a", "a")
+	c.Set("b", "bb")
+	c.Set("c", "")
+	for i := 0; i < 300; i++ {
+		c.Get("b")
+	}
+
+	var buf bytes.Buffer
+	if err := c.SnapshotBinary(&buf, encode); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+
+	r := New()
+	if err := r.RestoreBinary(bytes.NewReader(raw), decode); err != nil {
+		t.Fatal(err)
+	}
+	if l := r.Len(); l != 3 {
+		t.Errorf("Length was not restored: %v != 3", l)
+	}
+	if f := r.TotalFrequency(); f != 303 {
+		t.Errorf("Frequencies were not restored: %v != 303", f)
+	}
+	if v := r.Get("b"); v != "bb" {
+		t.Errorf("Value was not restored: %v != 'bb'", v)
+	}
+
+	if err := r.RestoreBinary(bytes.NewReader(raw[:len(raw)-1]), decode); err != io.ErrUnexpectedEOF {
+		t.Errorf("Truncated snapshot was not rejected: %v", err)
+	}
+	for _, corrupt := range [][]byte{
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		{0x01, 'a', 0x01, 0xff, 0xff, 0xff, 0xff, 0x0f},
+	} {
+		if err := r.RestoreBinary(bytes.NewReader(corrupt), decode); err == nil {
+			t.Errorf("Corrupt length was not rejected: %x", corrupt)
+		}
+	}
+	if l := r.Len(); l != 3 {
+		t.Errorf("Failed restore modified the cache: %v != 3", l)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	c := New()
+	if c.Rebalance() {
+		t.Error("Empty cache was rebuilt")
+	}
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	c.Get("c")
+	if c.Rebalance() {
+		t.Error("Clean cache was rebuilt")
+	}
+
+	// build a list with buckets 3, 1, (empty) 2, 1
+	entries := make(map[string]*cacheEntry)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		entries[key] = &cacheEntry{key: key, value: key}
+	}
+	c.values = entries
+	c.freqs = list.New()
+	c.len = len(entries)
+	for _, bucket := range []struct {
+		freq int
+		keys []string
+	}{{3, []string{"a"}}, {1, []string{"b"}}, {2, nil}, {1, []string{"c", "d"}}} {
+		li := &listEntry{freq: bucket.freq}
+		place := c.freqs.PushBack(li)
+		for _, key := range bucket.keys {
+			entries[key].freqNode = place
+			li.entries.PushBack(entries[key])
+		}
+	}
+
+	if !c.Rebalance() {
+		t.Error("Messy list was not rebuilt")
+	}
+	if n := c.BucketCount(); n != 2 {
+		t.Errorf("Bucket count is wrong: %v != 2", n)
+	}
+	for i, ev := range c.EvictionPreview(4) {
+		if key := []string{"b", "c", "d", "a"}[i]; ev.Key != key {
+			t.Errorf("Wrong order at %v: %v != %v", i, ev.Key, key)
+		}
+	}
+	if f := c.TotalFrequency(); f != 6 {
+		t.Errorf("Frequencies changed: %v != 6", f)
+	}
+	if c.Rebalance() {
+		t.Error("Rebuilt list was rebuilt again")
+	}
+}
+
+func TestRestoreSorted(t *testing.T) {
+	c := New()
+	c.Set("old", 0)
+	err := c.RestoreSorted([]SeededEntry{{"a", 1, 2}, {"b", 2, 1}})
+	if err != ErrUnsorted || c.Len() != 1 {
+		t.Errorf("Unsorted entries were restored: %v, %v", err, c.Len())
+	}
+	err = c.RestoreSorted([]SeededEntry{{"a", 1, 0}, {"b", 2, 1}, {"c", 3, 3}, {"d", 4, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 4 || c.BucketCount() != 2 || c.Get("old") != nil {
+		t.Errorf("Wrong contents: %v, %v", c.Len(), c.BucketCount())
+	}
+	if keys := c.KeysAtFrequency(3); len(keys) != 2 || keys[0] != "c" {
+		t.Errorf("Wrong keys at frequency 3: %v", keys)
+	}
+}
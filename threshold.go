@@ -0,0 +1,57 @@
+package lfu
+
+// thresholdHysteresis is how far below its fraction of UpperBound the
+// length must fall before a threshold fires downwards and re-arms.
+const thresholdHysteresis = 0.05
+
+type threshold struct {
+	fraction float64
+	fn       func(len, upper int)
+	// down makes fn fire on the downward crossing instead of the
+	// upward one
+	down  bool
+	above bool
+}
+
+// OnThreshold calls fn when the cache length crosses fraction of
+// UpperBound upwards.  It fires once when len reaches
+// fraction*UpperBound, and is armed again only after len drops below
+// (fraction-0.05)*UpperBound; the gap keeps a cache hovering around the
+// threshold from firing on every operation.  The check runs under the
+// lock after every Set and Delete, and does nothing while UpperBound
+// is 0.
+func (c *Cache) OnThreshold(fraction float64, fn func(len, upper int)) {
+	c.lock.Lock()
+	defer c.unlock()
+	c.thresholds = append(c.thresholds, &threshold{fraction: fraction, fn: fn})
+}
+
+// OnThresholdDown is like OnThreshold, but calls fn on the downward
+// crossing: once len drops below (fraction-0.05)*UpperBound after
+// having reached fraction*UpperBound.
+func (c *Cache) OnThresholdDown(fraction float64, fn func(len, upper int)) {
+	c.lock.Lock()
+	defer c.unlock()
+	c.thresholds = append(c.thresholds, &threshold{fraction: fraction, fn: fn, down: true})
+}
+
+func (c *Cache) checkThresholds() {
+	if c.UpperBound <= 0 {
+		return
+	}
+	upper := float64(c.UpperBound)
+	for _, t := range c.thresholds {
+		switch {
+		case !t.above && float64(c.len) >= t.fraction*upper:
+			t.above = true
+			if !t.down {
+				c.call(func() { t.fn(c.len, c.UpperBound) })
+			}
+		case t.above && float64(c.len) < (t.fraction-thresholdHysteresis)*upper:
+			t.above = false
+			if t.down {
+				c.call(func() { t.fn(c.len, c.UpperBound) })
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package lfu
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestOnThreshold(t *testing.T) {
+	var fired, down []int
+
+	c := New()
+	c.UpperBound = 100
+	c.LowerBound = 90
+	c.OnThreshold(0.5, func(len, upper int) {
+		fired = append(fired, len)
+	})
+	c.OnThresholdDown(0.5, func(len, upper int) {
+		down = append(down, len)
+	})
+	for i := 0; i < 60; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	// hovering around the threshold does not fire again
+	for i := 59; i >= 48; i-- {
+		c.Delete(fmt.Sprintf("%v", i))
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if len(fired) != 1 || fired[0] != 50 || len(down) != 0 {
+		t.Fatalf("Upward crossing fired wrong: %v", fired)
+	}
+	for i := 59; i >= 40; i-- {
+		c.Delete(fmt.Sprintf("%v", i))
+	}
+	if len(fired) != 1 || len(down) != 1 || down[0] != 44 {
+		t.Errorf("Downward crossing fired wrong: %v, %v", fired, down)
+	}
+	for i := 40; i < 50; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if len(fired) != 2 || len(down) != 1 {
+		t.Errorf("Threshold was not re-armed: %v, %v", fired, down)
+	}
+}
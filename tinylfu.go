@@ -0,0 +1,156 @@
+package lfu
+
+import "hash/fnv"
+
+const sketchDepth = 4
+
+// NewTinyLFU returns a Cache that filters new keys through a TinyLFU
+// admission policy.  Every Get and Set is recorded in a small
+// count-min sketch of width counters per row.  When the cache is full,
+// a new key is only admitted if its estimated frequency is higher than
+// that of the entry it would evict.
+func NewTinyLFU(width int) *Cache {
+	c := New()
+	c.sketch = newSketch(width)
+	return c
+}
+
+// ResetSketch clears the admission sketch.  It is a no-op for caches
+// not created with NewTinyLFU.
+func (c *Cache) ResetSketch() {
+	c.lock.Lock()
+	defer c.unlock()
+	if c.sketch != nil {
+		c.sketch.reset()
+	}
+}
+
+// admit reports whether a new key may be inserted without displacing
+// a more valuable entry.
+func (c *Cache) admit(key string) bool {
+	if c.sketch == nil || !c.full() {
+		return true
+	}
+	return c.beatsVictim(c.sketch.estimate(key))
+}
+
+// beatsVictim reports whether a key with the estimated frequency est
+// is worth more than the next victim.
+func (c *Cache) beatsVictim(est uint8) bool {
+	victim := c.victim()
+	if victim == nil {
+		return true
+	}
+	return est > c.sketch.estimate(victim.key)
+}
+
+// WouldAdmit reports whether Set(key, ...) would store the key right
+// now, applying HardCap and the TinyLFU admission policy the same way
+// Set does, without changing the cache or the sketch.  Keys already
+// present are always admitted.  The answer only holds until the next
+// operation on the cache.
+func (c *Cache) WouldAdmit(key string) bool {
+	c.lock.Lock()
+	defer c.unlock()
+	if _, ok := c.lookup(key); ok {
+		return true
+	}
+	if c.sketch != nil && c.full() {
+		// Set records the key in the sketch before checking
+		est := c.sketch.estimate(key)
+		if est < 255 {
+			est++
+		}
+		if !c.beatsVictim(est) {
+			return false
+		}
+	}
+	if c.HardCap > 0 && c.len >= c.HardCap {
+		if !c.HardCapEvict {
+			return false
+		}
+		need := c.len - c.HardCap + 1
+		victims, _, _ := c.victims(need)
+		return len(victims) == need
+	}
+	return true
+}
+
+// full reports whether inserting a new key would trigger eviction.
+func (c *Cache) full() bool {
+	if c.HardCap > 0 && c.len >= c.HardCap {
+		return true
+	}
+	upper, _ := c.bounds()
+	return upper > 0 && c.len >= upper
+}
+
+// sketch is a count-min sketch of saturating 8 bit counters.  After
+// every 10*width additions all counters are halved so that the
+// estimates track recent popularity.
+type sketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	sample    int
+}
+
+func newSketch(width int) *sketch {
+	size := 1
+	for size < width {
+		size <<= 1
+	}
+	s := &sketch{mask: uint64(size - 1), sample: 10 * size}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size)
+	}
+	return s
+}
+
+func (s *sketch) index(key string, row int) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{byte(row)})
+	h.Write([]byte(key))
+	return h.Sum64() & s.mask
+}
+
+func (s *sketch) add(key string) {
+	for i := range s.rows {
+		idx := s.index(key, i)
+		if s.rows[i][idx] < 255 {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sample {
+		s.age()
+	}
+}
+
+func (s *sketch) estimate(key string) uint8 {
+	min := uint8(255)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(key, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func (s *sketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = 0
+		}
+	}
+	s.additions = 0
+}
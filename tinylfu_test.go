@@ -0,0 +1,40 @@
+package lfu
+
+import "testing"
+
+func TestTinyLFUAdmission(t *testing.T) {
+	c := NewTinyLFU(64)
+	c.HardCap = 2
+	c.HardCapEvict = true
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Get("b")
+
+	// a one-hit wonder must not displace a warm entry
+	if c.WouldAdmit("c") {
+		t.Error("Cold key would be admitted")
+	}
+	if c.TrySet("c", 3) {
+		t.Error("Cold key was admitted")
+	}
+
+	// once it is popular enough it is admitted
+	for i := 0; i < 5; i++ {
+		c.Get("c")
+	}
+	if !c.WouldAdmit("c") {
+		t.Error("Hot key would not be admitted")
+	}
+	if !c.TrySet("c", 3) {
+		t.Error("Hot key was not admitted")
+	}
+	if l := c.Len(); l != 2 {
+		t.Errorf("Length is wrong: %v != 2", l)
+	}
+
+	c.ResetSketch()
+	if e := c.sketch.estimate("c"); e != 0 {
+		t.Errorf("Sketch was not reset: %v", e)
+	}
+}
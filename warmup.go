@@ -0,0 +1,33 @@
+package lfu
+
+// countOp counts a Get or Set towards WarmupOps.
+func (c *Cache) countOp() {
+	if c.warm || (c.WarmupOps <= 0 && c.WarmupDuration <= 0) {
+		return
+	}
+	c.ops++
+	if c.warmupStart.IsZero() {
+		c.warmupStart = c.now()
+	}
+}
+
+// warming reports whether the cache is still warming up.
+func (c *Cache) warming() bool {
+	if c.warm || (c.WarmupOps <= 0 && c.WarmupDuration <= 0) {
+		return false
+	}
+	if (c.WarmupOps > 0 && c.ops >= c.WarmupOps) ||
+		(c.WarmupDuration > 0 && c.now().Sub(c.warmupStart) >= c.WarmupDuration) {
+		c.warm = true
+		return false
+	}
+	return true
+}
+
+// warmupCap returns the length the cache may grow to during warmup.
+func (c *Cache) warmupCap() int {
+	if c.WarmupMaxLen > 0 {
+		return c.WarmupMaxLen
+	}
+	return 2 * c.UpperBound
+}
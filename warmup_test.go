@@ -0,0 +1,34 @@
+package lfu
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWarmup(t *testing.T) {
+	c := New()
+	c.UpperBound = 10
+	c.LowerBound = 5
+	c.WarmupOps = 19
+	c.WarmupMaxLen = 15
+	for i := 0; i < 15; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if n := c.Len(); n != 15 {
+		t.Errorf("Entries were evicted during warmup: %v != 15", n)
+	}
+	c.Set("15", 15)
+	if n := c.Len(); n != 5 {
+		t.Errorf("WarmupMaxLen was not enforced: %v != 5", n)
+	}
+	for i := 16; i < 21; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	if n := c.Len(); n != 10 {
+		t.Errorf("Warmup did not end: %v != 10", n)
+	}
+	c.Set("21", 21)
+	if n := c.Len(); n != 5 {
+		t.Errorf("Bounds were not enforced after warmup: %v != 5", n)
+	}
+}
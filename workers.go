@@ -0,0 +1,47 @@
+package lfu
+
+// dispatch hands ev to the EvictHandler workers, starting them on first
+// use.  It blocks while the queue is full.  After Close, the handler is
+// called synchronously instead.
+func (c *Cache) dispatch(ev Eviction) {
+	if c.closed {
+		c.call(func() { c.EvictHandler(ev) })
+		return
+	}
+	if c.evictQueue == nil {
+		workers := c.EvictWorkers
+		if workers < 1 {
+			workers = 1
+		}
+		c.evictQueue = make(chan Eviction, workers)
+		handler := c.EvictHandler
+		onPanic := c.OnCallbackPanic
+		c.evictWorkers.Add(workers)
+		for i := 0; i < workers; i++ {
+			go func() {
+				defer c.evictWorkers.Done()
+				for ev := range c.evictQueue {
+					protect(onPanic, func() { handler(ev) })
+				}
+			}()
+		}
+	}
+	c.evictQueue <- ev
+}
+
+// Close stops the EvictHandler workers after they have handled every
+// queued eviction.  Evictions after Close call EvictHandler
+// synchronously.
+func (c *Cache) Close() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed = true
+	if c.evictQueue != nil {
+		close(c.evictQueue)
+	}
+	c.lock.Unlock()
+	c.evictWorkers.Wait()
+}
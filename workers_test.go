@@ -0,0 +1,35 @@
+package lfu
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestEvictWorkers(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[string]bool)
+
+	c := New()
+	c.EvictWorkers = 4
+	c.EvictHandler = func(ev Eviction) {
+		mu.Lock()
+		handled[ev.Key] = true
+		mu.Unlock()
+	}
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("%v", i), i)
+	}
+	c.Evict(100)
+	c.Close()
+
+	if len(handled) != 100 {
+		t.Errorf("Not all evictions were handled: %v != 100", len(handled))
+	}
+
+	c.Set("x", 0)
+	c.Evict(1)
+	if !handled["x"] {
+		t.Error("Eviction after Close was not handled")
+	}
+}